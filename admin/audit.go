@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AuditEntry is one line of the append-only audit log
+type AuditEntry struct {
+	Time         time.Time     `json:"time"`
+	Actor        string        `json:"actor"`
+	TokenID      string        `json:"token_id,omitempty"`
+	IP           string        `json:"ip"`
+	Action       string        `json:"action"`
+	Script       string        `json:"script,omitempty"`
+	Before       *ScriptConfig `json:"before,omitempty"`
+	After        *ScriptConfig `json:"after,omitempty"`
+	BeforeSHA256 string        `json:"before_sha256,omitempty"`
+	AfterSHA256  string        `json:"after_sha256,omitempty"`
+	Details      string        `json:"details,omitempty"`
+}
+
+// The audit log is rotated once it grows past auditMaxSize. Rotated files are
+// numbered audit.jsonl.1 (oldest), audit.jsonl.2 and so on, and are never
+// renamed again or deleted.
+const auditMaxSize = 10 << 20
+
+var auditMu sync.Mutex
+
+func auditLogPath() string {
+	return filepath.Join(dataPath, "audit.jsonl")
+}
+
+func rotatedAuditPath(n int) string {
+	return fmt.Sprintf("%s.%d", auditLogPath(), n)
+}
+
+// rotatedAuditFiles returns the numbers of the rotated audit files, oldest
+// first
+func rotatedAuditFiles() ([]int, error) {
+	matches, err := filepath.Glob(auditLogPath() + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var numbers []int
+	for _, match := range matches {
+		n, err := strconv.Atoi(strings.TrimPrefix(match, auditLogPath()+"."))
+		if err == nil && n > 0 {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+// rotateAuditLog starts a new audit log if adding size bytes would take the
+// current one past auditMaxSize. auditMu must be held.
+func rotateAuditLog(size int) error {
+	info, err := os.Stat(auditLogPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size()+int64(size) <= auditMaxSize {
+		return nil
+	}
+
+	numbers, err := rotatedAuditFiles()
+	if err != nil {
+		return err
+	}
+	next := 1
+	if len(numbers) > 0 {
+		next = numbers[len(numbers)-1] + 1
+	}
+	return os.Rename(auditLogPath(), rotatedAuditPath(next))
+}
+
+// recordAudit appends an entry for the current request. Failures are logged
+// but never fail the request that has already taken effect.
+func recordAudit(c *fiber.Ctx, entry AuditEntry) {
+	entry.Time = time.Now().UTC()
+	entry.IP = c.IP()
+	if entry.Actor == "" {
+		entry.Actor = currentUser(c)
+	}
+	if token, ok := c.Locals("token").(APIToken); ok {
+		entry.TokenID = token.ID
+	}
+	writeAuditEntry(entry)
+}
+
+// writeAuditEntry appends an entry that is already filled in, for changes
+// made by the server itself rather than a request
+func writeAuditEntry(entry AuditEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Failed to encode audit entry: %v", err)
+		return
+	}
+	if err := appendAuditLine(line); err != nil {
+		log.Printf("Failed to write audit log: %v", err)
+	}
+
+	// Git and webhooks are slow; other entries need not wait for them
+	if gitRepo != nil {
+		gitRepo.CommitChange(entry)
+	}
+	if webhooks != nil {
+		webhooks.Dispatch(entry)
+	}
+}
+
+func appendAuditLine(line []byte) error {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	if err := os.MkdirAll(dataPath, 0755); err != nil {
+		return err
+	}
+	if err := rotateAuditLog(len(line) + 1); err != nil {
+		log.Printf("Failed to rotate audit log: %v", err)
+	}
+	f, err := os.OpenFile(auditLogPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// scriptPtr returns a pointer to a copy of script for audit before/after
+// fields
+func scriptPtr(script ScriptConfig) *ScriptConfig {
+	return &script
+}
+
+// fileHash returns the SHA-256 of a file, or "" if it cannot be read
+func fileHash(path string) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return contentHash(content)
+}
+
+type auditFilter struct {
+	Script string
+	Actor  string
+	Action string
+	Since  time.Time
+	Until  time.Time
+}
+
+func (f auditFilter) match(entry AuditEntry) bool {
+	if f.Script != "" && entry.Script != f.Script {
+		return false
+	}
+	if f.Actor != "" && entry.Actor != f.Actor {
+		return false
+	}
+	if f.Action != "" && entry.Action != f.Action {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// auditFile is an audit file opened for reading, up to its size when opened
+type auditFile struct {
+	f    *os.File
+	size int64
+}
+
+// openAuditFiles opens the rotated audit files and the current one, oldest
+// first. Writes only append to the current file and rotation only renames it,
+// so the files can be read without holding auditMu.
+func openAuditFiles() ([]auditFile, error) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	numbers, err := rotatedAuditFiles()
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, n := range numbers {
+		paths = append(paths, rotatedAuditPath(n))
+	}
+	paths = append(paths, auditLogPath())
+
+	var files []auditFile
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			var info os.FileInfo
+			if info, err = f.Stat(); err == nil {
+				files = append(files, auditFile{f, info.Size()})
+				continue
+			}
+			f.Close()
+		}
+		for _, file := range files {
+			file.f.Close()
+		}
+		return nil, err
+	}
+	return files, nil
+}
+
+// readAudit returns the newest entries matching filter, newest first,
+// including those in rotated files. Files are read newest first until limit
+// entries are found.
+func readAudit(filter auditFilter, limit int) ([]AuditEntry, error) {
+	files, err := openAuditFiles()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, file := range files {
+			file.f.Close()
+		}
+	}()
+
+	entries := []AuditEntry{}
+	for i := len(files) - 1; i >= 0; i-- {
+		fileEntries, err := readAuditFile(files[i], filter)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(fileEntries, entries...)
+		if limit > 0 && len(entries) >= limit {
+			break
+		}
+	}
+
+	// Newest first, keeping only the last `limit` matches
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// readAuditFile returns the entries of one audit file that match filter
+func readAuditFile(file auditFile, filter auditFilter) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(io.LimitReader(file.f, file.size))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if filter.match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// parseTimeParam accepts RFC 3339 timestamps or plain dates (YYYY-MM-DD)
+func parseTimeParam(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
+
+func getAuditAPI(c *fiber.Ctx) error {
+	filter := auditFilter{
+		Script: c.Query("script"),
+		Actor:  c.Query("user"),
+		Action: c.Query("action"),
+	}
+
+	var err error
+	if filter.Since, err = parseTimeParam(c.Query("since"), false); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid 'since' time, use RFC 3339 or YYYY-MM-DD"})
+	}
+	if filter.Until, err = parseTimeParam(c.Query("until"), true); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid 'until' time, use RFC 3339 or YYYY-MM-DD"})
+	}
+
+	limit, err := strconv.Atoi(c.Query("limit", "100"))
+	if err != nil || limit < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid limit"})
+	}
+
+	entries, err := readAudit(filter, limit)
+	if err != nil {
+		log.Printf("Failed to read audit log: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to read audit log"})
+	}
+	return c.JSON(entries)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAuditLogRotation(t *testing.T) {
+	oldDataPath := dataPath
+	dataPath = t.TempDir()
+	t.Cleanup(func() { dataPath = oldDataPath })
+
+	writeAuditEntry(AuditEntry{Actor: "alice", Action: "script.create", Script: "first"})
+	padAuditLog(t)
+	writeAuditEntry(AuditEntry{Actor: "alice", Action: "script.create", Script: "second"})
+
+	if info, err := os.Stat(rotatedAuditPath(1)); err != nil || info.Size() != auditMaxSize {
+		t.Fatalf("rotated log: %v, %v", info, err)
+	}
+	entries, err := readAudit(auditFilter{Actor: "alice"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Script != "second" || entries[1].Script != "first" {
+		t.Errorf("entries = %+v, want second then first", entries)
+	}
+
+	// Rotated files are numbered in order and none is ever dropped
+	for n := 2; n <= 4; n++ {
+		padAuditLog(t)
+		writeAuditEntry(AuditEntry{Actor: "bob", Action: "login", Details: fmt.Sprint(n)})
+		if _, err := os.Stat(rotatedAuditPath(n)); err != nil {
+			t.Fatalf("rotation %d: %v", n, err)
+		}
+	}
+	entries, err = readAudit(auditFilter{Script: "first"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("entries of the oldest file = %+v, want the first entry", entries)
+	}
+	entries, err = readAudit(auditFilter{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Details != "4" || entries[1].Details != "3" {
+		t.Errorf("newest entries = %+v, want the last two", entries)
+	}
+}
+
+// TestAuditReadDoesNotBlockWrites checks that entries can be written while
+// the log is being read
+func TestAuditReadDoesNotBlockWrites(t *testing.T) {
+	swapGlobal(t, &dataPath, t.TempDir())
+	writeAuditEntry(AuditEntry{Actor: "alice", Action: "login"})
+
+	files, err := openAuditFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer files[0].f.Close()
+
+	done := make(chan struct{})
+	go func() {
+		writeAuditEntry(AuditEntry{Actor: "bob", Action: "login"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("write blocked by an open read")
+	}
+
+	// The read sees the log as it was when it was opened
+	entries, err := readAuditFile(files[0], auditFilter{})
+	if err != nil || len(entries) != 1 || entries[0].Actor != "alice" {
+		t.Errorf("entries = %+v, %v", entries, err)
+	}
+}
+
+// padAuditLog fills the audit log up to its limit with lines that are not
+// entries, so that the next entry starts a new file
+func padAuditLog(t *testing.T) {
+	t.Helper()
+	info, err := os.Stat(auditLogPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(auditLogPath(), os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for size := int(info.Size()); size < auditMaxSize; size += 64 << 10 {
+		line := bytes.Repeat([]byte{'x'}, min(64<<10, auditMaxSize-size))
+		line[len(line)-1] = '\n'
+		if _, err := f.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
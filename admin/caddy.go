@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Redirect routes are managed individually through Caddy's JSON admin API.
+// Each route carries an @id so it can be replaced or deleted atomically
+// without touching the rest of the configuration.
+const caddyRouteIDPrefix = "script-redirect-"
+
+var (
+	caddyAdminURL string
+	caddyServer   string
+	caddyClient   = &http.Client{Timeout: 5 * time.Second}
+)
+
+func initCaddy() {
+	caddyAdminURL = strings.TrimSuffix(os.Getenv("CADDY_ADMIN_URL"), "/")
+	if caddyAdminURL == "" {
+		caddyAdminURL = "http://script-server:2019" // Use service name from docker-compose
+	}
+	caddyServer = os.Getenv("CADDY_SERVER")
+	if caddyServer == "" {
+		caddyServer = "srv0" // Name the Caddyfile adapter gives the first server
+	}
+}
+
+type caddyRoute struct {
+	ID       string           `json:"@id"`
+	Match    []map[string]any `json:"match,omitempty"`
+	Handle   []map[string]any `json:"handle"`
+	Terminal bool             `json:"terminal,omitempty"`
+}
+
+func caddyRouteID(scriptName string) string {
+	return caddyRouteIDPrefix + scriptName
+}
+
+func redirectRoute(scriptName, redirectURL string) caddyRoute {
+	return caddyRoute{
+		ID: caddyRouteID(scriptName),
+		Match: []map[string]any{
+			{"path": []string{"/" + scriptName, "/" + scriptName + "/"}},
+		},
+		Handle: []map[string]any{
+			{
+				"handler":     "static_response",
+				"status_code": 302,
+				"headers":     map[string][]string{"Location": {redirectURL}},
+			},
+		},
+		Terminal: true,
+	}
+}
+
+// caddyRequest sends a request to the Caddy admin API. It returns the HTTP
+// status code so callers can treat 404 as "route does not exist".
+func caddyRequest(method, path string, body any) (int, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, caddyAdminURL+path, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := caddyClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, respBody, nil
+}
+
+func caddyError(action string, status int, body []byte) error {
+	return fmt.Errorf("Caddy %s failed: %d %s", action, status, strings.TrimSpace(string(body)))
+}
+
+// upsertCaddyRedirect replaces the redirect route for a script, or inserts it
+// ahead of the catch-all script routes if it does not exist yet.
+func upsertCaddyRedirect(scriptName, redirectURL string) (err error) {
+	if !caddyEnabled {
+		return nil
+	}
+	defer func() { recordCaddyOperation("route_upsert", err) }()
+	return putCaddyRedirect(scriptName, redirectURL)
+}
+
+// removeCaddyRedirect deletes the redirect route for a script. Deleting a
+// route that does not exist is not an error.
+func removeCaddyRedirect(scriptName string) (err error) {
+	if !caddyEnabled {
+		return nil
+	}
+	defer func() { recordCaddyOperation("route_delete", err) }()
+	return deleteCaddyRedirect(scriptName)
+}
+
+// putCaddyRedirect and deleteCaddyRedirect do the work of upserting and
+// removing a route. They record no metrics, so a sync counts as one operation.
+func putCaddyRedirect(scriptName, redirectURL string) error {
+	route := redirectRoute(scriptName, redirectURL)
+
+	status, body, err := caddyRequest(http.MethodPatch, "/id/"+route.ID, route)
+	if err != nil {
+		return err
+	}
+	if status < 300 {
+		return nil
+	}
+	if status != http.StatusNotFound {
+		return caddyError("route update", status, body)
+	}
+
+	status, body, err = caddyRequest(http.MethodPut, fmt.Sprintf("/config/apps/http/servers/%s/routes/0", caddyServer), route)
+	if err != nil {
+		return err
+	}
+	if status >= 300 {
+		return caddyError("route insert", status, body)
+	}
+	return nil
+}
+
+func deleteCaddyRedirect(scriptName string) error {
+	status, body, err := caddyRequest(http.MethodDelete, "/id/"+caddyRouteID(scriptName), nil)
+	if err != nil {
+		return err
+	}
+	if status >= 300 && status != http.StatusNotFound {
+		return caddyError("route delete", status, body)
+	}
+	return nil
+}
+
+// syncCaddyRedirects makes Caddy's redirect routes match the configured
+// redirect scripts, removing routes left behind by deleted scripts.
+func syncCaddyRedirects(scripts []ScriptConfig) (err error) {
+	if !caddyEnabled {
+		return nil
+	}
+	defer func() { recordCaddyOperation("sync", err) }()
+
+	status, body, err := caddyRequest(http.MethodGet, fmt.Sprintf("/config/apps/http/servers/%s/routes", caddyServer), nil)
+	if err != nil {
+		return err
+	}
+	if status >= 300 {
+		return caddyError("route listing", status, body)
+	}
+
+	var existing []struct {
+		ID string `json:"@id"`
+	}
+	if err := json.Unmarshal(body, &existing); err != nil {
+		return err
+	}
+
+	wanted := map[string]bool{}
+	for _, script := range scripts {
+		if script.Type == "redirect" && script.RedirectURL != "" {
+			wanted[caddyRouteID(script.Name)] = true
+			if err := putCaddyRedirect(script.Name, script.RedirectURL); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, route := range existing {
+		if strings.HasPrefix(route.ID, caddyRouteIDPrefix) && !wanted[route.ID] {
+			if err := deleteCaddyRedirect(strings.TrimPrefix(route.ID, caddyRouteIDPrefix)); err != nil {
+				return err
+			}
+		}
+	}
+
+	log.Printf("Synced %d redirect routes with Caddy", len(wanted))
+	return nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// fakeCaddy implements the parts of Caddy's admin API the redirect routes
+// use: listing and inserting server routes, and replacing and deleting a
+// route by @id.
+type fakeCaddy struct {
+	mu     sync.Mutex
+	routes []map[string]any
+}
+
+func (f *fakeCaddy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	const routes = "/config/apps/http/servers/srv0/routes"
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == routes:
+		json.NewEncoder(w).Encode(f.routes)
+	case r.Method == http.MethodPut && r.URL.Path == routes+"/0":
+		route, ok := decodeRoute(w, r)
+		if ok {
+			f.routes = append([]map[string]any{route}, f.routes...)
+		}
+	case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/id/"):
+		i := f.index(strings.TrimPrefix(r.URL.Path, "/id/"))
+		if i < 0 {
+			http.Error(w, "unknown object ID", http.StatusNotFound)
+			return
+		}
+		if route, ok := decodeRoute(w, r); ok {
+			f.routes[i] = route
+		}
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/id/"):
+		i := f.index(strings.TrimPrefix(r.URL.Path, "/id/"))
+		if i < 0 {
+			http.Error(w, "unknown object ID", http.StatusNotFound)
+			return
+		}
+		f.routes = append(f.routes[:i], f.routes[i+1:]...)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func decodeRoute(w http.ResponseWriter, r *http.Request) (map[string]any, bool) {
+	body, _ := io.ReadAll(r.Body)
+	var route map[string]any
+	if err := json.Unmarshal(body, &route); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return route, true
+}
+
+func (f *fakeCaddy) index(id string) int {
+	for i, route := range f.routes {
+		if route["@id"] == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// location returns the redirect target of the route with the given @id
+func (f *fakeCaddy) location(id string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i := f.index(id)
+	if i < 0 {
+		return "", false
+	}
+	handle := f.routes[i]["handle"].([]any)[0].(map[string]any)
+	return handle["headers"].(map[string]any)["Location"].([]any)[0].(string), true
+}
+
+func (f *fakeCaddy) ids() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []string
+	for _, route := range f.routes {
+		id, _ := route["@id"].(string)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func startFakeCaddy(t *testing.T, routes ...map[string]any) *fakeCaddy {
+	t.Helper()
+	fake := &fakeCaddy{routes: routes}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	oldURL, oldServer, oldEnabled := caddyAdminURL, caddyServer, caddyEnabled
+	caddyAdminURL, caddyServer, caddyEnabled = server.URL, "srv0", true
+	t.Cleanup(func() { caddyAdminURL, caddyServer, caddyEnabled = oldURL, oldServer, oldEnabled })
+	return fake
+}
+
+func TestUpsertCaddyRedirect(t *testing.T) {
+	fake := startFakeCaddy(t, map[string]any{"@id": "catch-all", "handle": []any{}})
+
+	if err := upsertCaddyRedirect("docker", "https://get.docker.com"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.ids(); len(got) != 2 || got[0] != "script-redirect-docker" {
+		t.Fatalf("routes after insert = %v, want the redirect ahead of the catch-all", got)
+	}
+
+	if err := upsertCaddyRedirect("docker", "https://example.com/docker.sh"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.ids(); len(got) != 2 {
+		t.Fatalf("routes after update = %v, want the redirect replaced", got)
+	}
+	if location, _ := fake.location("script-redirect-docker"); location != "https://example.com/docker.sh" {
+		t.Errorf("Location = %q, want the updated URL", location)
+	}
+}
+
+func TestRemoveCaddyRedirect(t *testing.T) {
+	fake := startFakeCaddy(t, redirectRouteMap(t, "docker", "https://get.docker.com"))
+
+	if err := removeCaddyRedirect("docker"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.ids(); len(got) != 0 {
+		t.Fatalf("routes after remove = %v, want none", got)
+	}
+	if err := removeCaddyRedirect("docker"); err != nil {
+		t.Errorf("removing a missing route: %v", err)
+	}
+}
+
+func TestSyncCaddyRedirects(t *testing.T) {
+	fake := startFakeCaddy(t,
+		redirectRouteMap(t, "old", "https://example.com/old.sh"),
+		redirectRouteMap(t, "docker", "https://get.docker.com"),
+		map[string]any{"@id": "catch-all", "handle": []any{}},
+	)
+	scripts := []ScriptConfig{
+		{Name: "docker", Type: "redirect", RedirectURL: "https://example.com/docker.sh"},
+		{Name: "k3s", Type: "redirect", RedirectURL: "https://get.k3s.io"},
+		{Name: "local", Type: "local"},
+	}
+
+	upserts := caddyOperations(t, "route_upsert", "success")
+	syncs := caddyOperations(t, "sync", "success")
+	if err := syncCaddyRedirects(scripts); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := fake.location("script-redirect-old"); ok {
+		t.Error("route of a deleted script was kept")
+	}
+	if location, _ := fake.location("script-redirect-docker"); location != "https://example.com/docker.sh" {
+		t.Errorf("docker Location = %q, want the configured URL", location)
+	}
+	if location, _ := fake.location("script-redirect-k3s"); location != "https://get.k3s.io" {
+		t.Errorf("k3s Location = %q, want the configured URL", location)
+	}
+	if _, ok := fake.location("script-redirect-local"); ok {
+		t.Error("local script got a redirect route")
+	}
+	if got := fake.ids(); got[len(got)-1] != "catch-all" {
+		t.Error("route not managed by the server was removed")
+	}
+
+	if got := caddyOperations(t, "sync", "success") - syncs; got != 1 {
+		t.Errorf("sync counted %v times, want 1", got)
+	}
+	if got := caddyOperations(t, "route_upsert", "success") - upserts; got != 0 {
+		t.Errorf("sync counted %v upserts, want none", got)
+	}
+}
+
+func redirectRouteMap(t *testing.T, scriptName, redirectURL string) map[string]any {
+	t.Helper()
+	data, err := json.Marshal(redirectRoute(scriptName, redirectURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var route map[string]any
+	if err := json.Unmarshal(data, &route); err != nil {
+		t.Fatal(err)
+	}
+	return route
+}
+
+func caddyOperations(t *testing.T, operation, result string) float64 {
+	t.Helper()
+	var metric dto.Metric
+	if err := caddyOperationsTotal.WithLabelValues(operation, result).Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetCounter().GetValue()
+}
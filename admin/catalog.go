@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Scripts carry an optional category and tags. The index page groups
+// scripts by category, and the scripts API filters on both. The same
+// scripts are published as a machine-readable catalog.
+
+const (
+	maxTagLength      = 32
+	maxCategoryLength = 64
+	// uncategorized heads the group of scripts without a category
+	uncategorized = "Other"
+)
+
+var validTag = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// normalizeTags lowercases and trims tags, dropping empty ones and
+// duplicates, and sorts them
+func normalizeTags(tags []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// validateCatalogFields checks a script's category and tags, which end up on
+// the index page and in filter queries
+func validateCatalogFields(script ScriptConfig) error {
+	if len(script.Category) > maxCategoryLength {
+		return fmt.Errorf("category of script %q is longer than %d characters", script.Name, maxCategoryLength)
+	}
+	for _, tag := range script.Tags {
+		if len(tag) > maxTagLength || !validTag.MatchString(tag) {
+			return fmt.Errorf("script %q has invalid tag %q: use lowercase letters, digits, '.', '_' and '-'", script.Name, tag)
+		}
+	}
+	return nil
+}
+
+func (s ScriptConfig) hasTag(tag string) bool {
+	for _, t := range s.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// scriptFilter selects scripts for GET /admin/scripts. Text matches are
+// case-insensitive; every given condition must hold.
+type scriptFilter struct {
+	query    string // in name, description, category or tags
+	content  string // in the served content
+	category string
+	tags     []string // all of them
+	typ      string
+}
+
+func parseScriptFilter(c *fiber.Ctx) scriptFilter {
+	f := scriptFilter{
+		query:    strings.ToLower(strings.TrimSpace(c.Query("q"))),
+		content:  strings.ToLower(strings.TrimSpace(c.Query("content"))),
+		category: strings.TrimSpace(c.Query("category")),
+		typ:      c.Query("type"),
+	}
+	// ?tag=a&tag=b and ?tag=a,b are the same
+	var tags []string
+	for _, value := range c.Context().QueryArgs().PeekMulti("tag") {
+		tags = append(tags, strings.Split(string(value), ",")...)
+	}
+	f.tags = normalizeTags(tags)
+	return f
+}
+
+func (f scriptFilter) empty() bool {
+	return f.query == "" && f.content == "" && f.category == "" && len(f.tags) == 0 && f.typ == ""
+}
+
+func (f scriptFilter) matches(script ScriptConfig) bool {
+	if f.typ != "" && script.Type != f.typ {
+		return false
+	}
+	if f.category != "" && !strings.EqualFold(script.Category, f.category) {
+		return false
+	}
+	for _, tag := range f.tags {
+		if !script.hasTag(tag) {
+			return false
+		}
+	}
+	if f.query != "" {
+		fields := strings.ToLower(strings.Join(append([]string{script.Name, script.Description, script.Category}, script.Tags...), "\n"))
+		if !strings.Contains(fields, f.query) {
+			return false
+		}
+	}
+	if f.content != "" {
+		path, ok := servedScriptFile(script)
+		if !ok {
+			return false
+		}
+		content, err := os.ReadFile(path)
+		if err != nil || !bytes.Contains(bytes.ToLower(content), []byte(f.content)) {
+			return false
+		}
+	}
+	return true
+}
+
+// scriptCategory is a group of scripts on the index page
+type scriptCategory struct {
+	Name    string
+	Scripts []ScriptConfig
+}
+
+// groupByCategory sorts categories by name, with uncategorized scripts last,
+// and keeps the configured order of scripts within each category
+func groupByCategory(scripts []ScriptConfig) []scriptCategory {
+	index := map[string]int{}
+	var groups []scriptCategory
+	var other []ScriptConfig
+	for _, script := range scripts {
+		name := strings.TrimSpace(script.Category)
+		if name == "" || strings.EqualFold(name, uncategorized) {
+			other = append(other, script)
+			continue
+		}
+		key := strings.ToLower(name)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, scriptCategory{Name: name})
+		}
+		groups[i].Scripts = append(groups[i].Scripts, script)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return strings.ToLower(groups[i].Name) < strings.ToLower(groups[j].Name)
+	})
+	if len(other) > 0 {
+		groups = append(groups, scriptCategory{Name: uncategorized, Scripts: other})
+	}
+	return groups
+}
+
+// allTags returns the tags used by scripts, sorted
+func allTags(scripts []ScriptConfig) []string {
+	var tags []string
+	for _, script := range scripts {
+		tags = append(tags, script.Tags...)
+	}
+	return normalizeTags(tags)
+}
+
+// escapeHTML escapes text for the generated index page
+func escapeHTML(s string) string {
+	return html.EscapeString(s)
+}
+
+// CatalogEntry describes a script in the public catalog. Content fields are
+// left out for redirect scripts, templates and mirrors not fetched yet.
+type CatalogEntry struct {
+	Name         string     `json:"name" yaml:"name"`
+	Description  string     `json:"description" yaml:"description"`
+	Icon         string     `json:"icon" yaml:"icon"`
+	Type         string     `json:"type" yaml:"type"`
+	Category     string     `json:"category,omitempty" yaml:"category,omitempty"`
+	Tags         []string   `json:"tags" yaml:"tags"`
+	URL          string     `json:"url" yaml:"url"`
+	SHA256       string     `json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	Size         *int64     `json:"size,omitempty" yaml:"size,omitempty"`
+	LastModified *time.Time `json:"last_modified,omitempty" yaml:"last_modified,omitempty"`
+	SignatureURL string     `json:"signature_url,omitempty" yaml:"signature_url,omitempty"`
+	// Template scripts are rendered with Params from the query string
+	Template bool          `json:"template,omitempty" yaml:"template,omitempty"`
+	Params   []ScriptParam `json:"params,omitempty" yaml:"params,omitempty"`
+}
+
+// Catalog is served at /catalog.json and /catalog.yaml for tools that
+// discover scripts
+type Catalog struct {
+	Scripts []CatalogEntry `json:"scripts" yaml:"scripts"`
+}
+
+// publicBaseURL is PUBLIC_URL, for deployments where TLS ends before the
+// request reaches this server, or else the address the request came in on.
+// In the latter case the response depends on the Host header, and caches
+// are told so.
+func publicBaseURL(c *fiber.Ctx) string {
+	if base := os.Getenv("PUBLIC_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	c.Vary(fiber.HeaderHost)
+	return c.BaseURL()
+}
+
+// buildCatalog lists the scripts shown on the index page
+func buildCatalog(c *fiber.Ctx) Catalog {
+	base := publicBaseURL(c)
+	catalog := Catalog{Scripts: []CatalogEntry{}}
+
+	for _, script := range indexScripts(configStore.Scripts()) {
+		scriptType := script.Type
+		if scriptType == "" {
+			scriptType = "local"
+		}
+		entry := CatalogEntry{
+			Name:        script.Name,
+			Description: script.Description,
+			Icon:        script.Icon,
+			Type:        scriptType,
+			Category:    script.Category,
+			Tags:        script.Tags,
+			URL:         base + "/" + script.Name,
+		}
+		if entry.Tags == nil {
+			entry.Tags = []string{}
+		}
+
+		if script.Template {
+			entry.Template = true
+			entry.Params = script.Params
+		} else if path, ok := servedScriptFile(script); ok {
+			if info, err := os.Stat(path); err == nil {
+				size := info.Size()
+				modTime := info.ModTime().UTC().Truncate(time.Second)
+				entry.Size = &size
+				entry.LastModified = &modTime
+				entry.SHA256 = fileHash(path)
+			}
+			if _, err := os.Stat(signatureFile(script.Name)); err == nil {
+				entry.SignatureURL = base + "/" + script.Name + ".sig"
+			}
+		}
+		catalog.Scripts = append(catalog.Scripts, entry)
+	}
+	return catalog
+}
+
+func catalogJSONHandler(c *fiber.Ctx) error {
+	data, err := json.MarshalIndent(buildCatalog(c), "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode catalog: %v", err)
+		return c.Status(500).SendString("Failed to build catalog")
+	}
+	return sendGenerated(c, data, fiber.MIMEApplicationJSONCharsetUTF8)
+}
+
+func catalogYAMLHandler(c *fiber.Ctx) error {
+	data, err := yaml.Marshal(buildCatalog(c))
+	if err != nil {
+		log.Printf("Failed to encode catalog: %v", err)
+		return c.Status(500).SendString("Failed to build catalog")
+	}
+	return sendGenerated(c, data, "application/yaml; charset=utf-8")
+}
+
+// sendGenerated serves a generated document with an ETag of its content. It
+// has no single modification time, so only If-None-Match applies.
+func sendGenerated(c *fiber.Ctx, data []byte, contentType string) error {
+	etag := `"` + contentHash(data) + `"`
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderETag, etag)
+
+	if c.Get(fiber.HeaderIfNoneMatch) != "" && notModified(c, etag, time.Time{}) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	return c.Send(data)
+}
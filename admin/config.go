@@ -0,0 +1,348 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	errScriptExists   = errors.New("script already exists")
+	errScriptNotFound = errors.New("script not found")
+	errConfigChanged  = errors.New("config file changed on disk since it was last read")
+	errInvalidConfig  = errors.New("invalid configuration")
+)
+
+// invalidConfigError is returned by Update when the changed configuration
+// fails validation. Its message says why, for the client that made the change.
+type invalidConfigError struct{ err error }
+
+func (e invalidConfigError) Error() string        { return e.err.Error() }
+func (e invalidConfigError) Is(target error) bool { return target == errInvalidConfig }
+
+// ConfigStore guards the loaded configuration. Readers get copies; writers go
+// through Update, which persists the new configuration before publishing it.
+type ConfigStore struct {
+	mu   sync.RWMutex
+	path string
+	cfg  Config
+	// diskHash is the SHA-256 of the file as last read or written, used to
+	// detect edits made outside the admin API.
+	diskHash string
+}
+
+func newConfigStore(path string) *ConfigStore {
+	return &ConfigStore{path: path}
+}
+
+// Load reads, parses and validates the configuration file
+func (s *ConfigStore) Load() error {
+	_, err := s.Reload()
+	return err
+}
+
+// Reload re-reads the configuration file if its content changed since it was
+// last read or written. An invalid file is rejected and the current
+// configuration stays in effect.
+func (s *ConfigStore) Reload() (bool, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return false, err
+	}
+	hash := contentHash(data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if hash == s.diskHash {
+		return false, nil
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return false, err
+	}
+	if err := validateConfig(cfg); err != nil {
+		return false, err
+	}
+
+	s.cfg = cfg
+	s.diskHash = hash
+	return true, nil
+}
+
+// Get returns a copy of the current configuration
+func (s *ConfigStore) Get() Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg.clone()
+}
+
+// Scripts returns a copy of the configured scripts
+func (s *ConfigStore) Scripts() []ScriptConfig {
+	return s.Get().Scripts
+}
+
+// Update applies fn to a copy of the configuration and saves the result. The
+// in-memory configuration only changes once the file has been written, and
+// concurrent updates are serialized. A result that validateConfig rejects is
+// not saved, so the file always loads on the next start.
+func (s *ConfigStore) Update(fn func(cfg *Config) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Refuse to clobber edits made on disk that have not been loaded yet
+	if current, err := os.ReadFile(s.path); err == nil && contentHash(current) != s.diskHash {
+		configSaveErrorsTotal.WithLabelValues("conflict").Inc()
+		return errConfigChanged
+	}
+
+	cfg := s.cfg.clone()
+	if err := fn(&cfg); err != nil {
+		return err
+	}
+	if err := validateConfig(cfg); err != nil {
+		configSaveErrorsTotal.WithLabelValues("invalid").Inc()
+		return invalidConfigError{err}
+	}
+
+	data, err := yaml.Marshal(&cfg)
+	if err != nil {
+		configSaveErrorsTotal.WithLabelValues("encode").Inc()
+		return err
+	}
+	if err := backupFile(s.path, 0644); err != nil {
+		log.Printf("Failed to back up %s: %v", s.path, err)
+	}
+	if err := writeFileAtomic(s.path, data, 0644); err != nil {
+		configSaveErrorsTotal.WithLabelValues("write").Inc()
+		return err
+	}
+
+	s.cfg = cfg
+	s.diskHash = contentHash(data)
+	return nil
+}
+
+// Exclusive runs fn while no update or reload can run, for changes made to
+// the file by other means, such as a git rebase
+func (s *ConfigStore) Exclusive(fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn()
+}
+
+// Watch polls the configuration file and reloads it when it changes on disk,
+// then regenerates everything derived from it.
+func (s *ConfigStore) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastErr string
+	for range ticker.C {
+		changed, err := s.Reload()
+		if err != nil {
+			if err.Error() != lastErr {
+				log.Printf("Ignoring invalid config file %s: %v", s.path, err)
+				lastErr = err.Error()
+			}
+			continue
+		}
+		lastErr = ""
+		if !changed {
+			continue
+		}
+
+		log.Printf("Reloaded config from %s", s.path)
+		updateIndexPageWithCurrentScripts()
+		sourceSyncer.Trigger()
+		mirrorCache.Trigger()
+		healthChecker.Trigger()
+		if err := syncCaddyRedirects(s.Scripts()); err != nil {
+			log.Printf("Failed to sync redirect routes with Caddy: %v", err)
+		}
+	}
+}
+
+// validateConfig checks a configuration before it replaces the running one
+func validateConfig(cfg Config) error {
+	if err := validateUsers(cfg.users()); err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for _, script := range cfg.Scripts {
+		if script.Name == "" {
+			return errors.New("script with empty name")
+		}
+		if seen[script.Name] {
+			return fmt.Errorf("duplicate script name %q", script.Name)
+		}
+		seen[script.Name] = true
+
+		switch script.Type {
+		case "", "local":
+		case "redirect", "mirror":
+			if script.RedirectURL == "" {
+				return fmt.Errorf("%s script %q has no redirect_url", script.Type, script.Name)
+			}
+		default:
+			return fmt.Errorf("script %q has unknown type %q", script.Name, script.Type)
+		}
+
+		if script.Git != nil {
+			if script.Type != "" && script.Type != "local" {
+				return fmt.Errorf("script %q has a git source but is not local", script.Name)
+			}
+			if err := script.Git.validate(); err != nil {
+				return fmt.Errorf("git source of script %q is invalid: %v", script.Name, err)
+			}
+		}
+
+		if err := validateCatalogFields(script); err != nil {
+			return err
+		}
+		if err := validateTemplateFields(script); err != nil {
+			return err
+		}
+
+		switch script.Status {
+		case "", statusPublished, statusPendingReview:
+		default:
+			return fmt.Errorf("script %q has unknown status %q", script.Name, script.Status)
+		}
+	}
+	if gitRepo != nil && gitRepo.remote != "" {
+		if err := checkInlineSecrets(cfg.Webhooks); err != nil {
+			return err
+		}
+	}
+	return validateWebhooks(cfg.Webhooks)
+}
+
+func (c Config) clone() Config {
+	out := c
+	if c.Users != nil {
+		out.Users = make([]User, len(c.Users))
+		copy(out.Users, c.Users)
+	}
+	if c.Scripts != nil {
+		out.Scripts = make([]ScriptConfig, len(c.Scripts))
+		for i, script := range c.Scripts {
+			out.Scripts[i] = script.clone()
+		}
+	}
+	if c.Webhooks != nil {
+		out.Webhooks = make([]Webhook, len(c.Webhooks))
+		for i, hook := range c.Webhooks {
+			out.Webhooks[i] = hook
+			out.Webhooks[i].Events = cloneStrings(hook.Events)
+		}
+	}
+	return out
+}
+
+// clone copies a script deeply enough that changing the copy, including its
+// tags, params and git source, leaves the original alone
+func (s ScriptConfig) clone() ScriptConfig {
+	out := s
+	out.Tags = cloneStrings(s.Tags)
+	if s.Params != nil {
+		out.Params = make([]ScriptParam, len(s.Params))
+		for i, param := range s.Params {
+			out.Params[i] = param
+			out.Params[i].Allowed = cloneStrings(param.Allowed)
+		}
+	}
+	if s.Git != nil {
+		git := *s.Git
+		out.Git = &git
+	}
+	return out
+}
+
+func cloneStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	return append([]string(nil), values...)
+}
+
+// backupFile copies path to path.bak, so that the previous version can be
+// restored by hand. Only config.yaml is backed up: other files are either
+// regenerated or, like script content, kept as revisions.
+func backupFile(path string, perm os.FileMode) error {
+	previous, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+".bak", previous, perm)
+}
+
+// writeFileAtomic replaces path with data via a synced temp file and rename
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		// A single file bind-mounted into a container cannot be replaced by
+		// rename, so fall back to rewriting it in place.
+		if errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EXDEV) {
+			return writeFileInPlace(path, data)
+		}
+		return err
+	}
+
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+func writeFileInPlace(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testConfig = `admin:
+  username: admin
+  password_hash: "$2a$10$z.oGWDtg8Ah3kuOt6IKZW.vdvcDKVm1aVueIVUiDKFiu/aAV3nOuy"
+scripts:
+  - name: docker
+    path: docker
+    description: Install Docker
+    icon: "🐳"
+    type: local
+    tags: [containers, docker]
+    template: true
+    params:
+      - name: channel
+        default: stable
+        allowed: [stable, test]
+    git:
+      repo: https://example.com/scripts.git
+      path: docker.sh
+webhooks:
+  - name: ci
+    url: https://ci.example.com/hook
+    events: ["script.*"]
+`
+
+func newTestConfigStore(t *testing.T) *ConfigStore {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := newConfigStore(path)
+	if err := store.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestConfigGetReturnsDeepCopy(t *testing.T) {
+	store := newTestConfigStore(t)
+
+	cfg := store.Get()
+	cfg.Scripts[0].Tags[0] = "changed"
+	cfg.Scripts[0].Params[0].Allowed[0] = "changed"
+	cfg.Scripts[0].Git.Ref = "changed"
+	cfg.Webhooks[0].Events[0] = "changed"
+
+	script := store.Get().Scripts[0]
+	if script.Tags[0] != "containers" {
+		t.Errorf("tags changed through a copy: %v", script.Tags)
+	}
+	if script.Params[0].Allowed[0] != "stable" {
+		t.Errorf("allowed values changed through a copy: %v", script.Params[0].Allowed)
+	}
+	if script.Git.Ref != "" {
+		t.Errorf("git source changed through a copy: %+v", script.Git)
+	}
+	if events := store.Get().Webhooks[0].Events; events[0] != "script.*" {
+		t.Errorf("webhook events changed through a copy: %v", events)
+	}
+}
+
+func TestConfigUpdateFailureKeepsConfig(t *testing.T) {
+	store := newTestConfigStore(t)
+
+	err := store.Update(func(cfg *Config) error {
+		cfg.Scripts[0].Tags[0] = "changed"
+		return errScriptExists
+	})
+	if err != errScriptExists {
+		t.Fatalf("Update() = %v, want errScriptExists", err)
+	}
+	if tags := store.Get().Scripts[0].Tags; tags[0] != "containers" {
+		t.Errorf("failed update changed tags: %v", tags)
+	}
+}
+
+func TestConfigUpdateRejectsInvalidConfig(t *testing.T) {
+	store := newTestConfigStore(t)
+	before, err := os.ReadFile(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(func(cfg *Config) error {
+		cfg.Scripts[0].Type = "bogus"
+		return nil
+	})
+	if !errors.Is(err, errInvalidConfig) || !strings.Contains(err.Error(), `unknown type "bogus"`) {
+		t.Fatalf("Update() = %v, want the validation error", err)
+	}
+	if after, _ := os.ReadFile(store.path); string(after) != string(before) {
+		t.Error("invalid config was written")
+	}
+	if script := store.Get().Scripts[0]; script.Type != "local" {
+		t.Errorf("type = %q, want the previous one", script.Type)
+	}
+}
+
+// TestConfigConcurrentAccess is meant for go test -race: readers change
+// their copies while writers update the store.
+func TestConfigConcurrentAccess(t *testing.T) {
+	store := newTestConfigStore(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				err := store.Update(func(cfg *Config) error {
+					script := &cfg.Scripts[0]
+					script.Tags[0] = fmt.Sprintf("tag-%d-%d", i, j)
+					script.Params[0].Allowed[1] = fmt.Sprintf("value-%d-%d", i, j)
+					script.Git.Ref = fmt.Sprintf("ref-%d-%d", i, j)
+					cfg.Webhooks[0].Events = append(cfg.Webhooks[0].Events[:0], "script.update")
+					return nil
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				cfg := store.Get()
+				script := &cfg.Scripts[0]
+				script.Tags[0] = "reader"
+				script.Params[0].Allowed[1] = "reader"
+				script.Git.Ref = "reader"
+				cfg.Webhooks[0].Events[0] = "reader"
+				_ = store.Scripts()
+			}
+		}()
+	}
+	wg.Wait()
+
+	script := store.Get().Scripts[0]
+	if script.Tags[0] == "reader" || script.Params[0].Allowed[1] == "reader" || script.Git.Ref == "reader" {
+		t.Errorf("reader changes leaked into the store: %+v", script)
+	}
+}
+
+func TestConfigUpdateKeepsBackup(t *testing.T) {
+	store := newTestConfigStore(t)
+	first, err := os.ReadFile(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, description := range []string{"First change", "Second change"} {
+		err := store.Update(func(cfg *Config) error {
+			cfg.Scripts[0].Description = description
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	saved, _ := os.ReadFile(store.path)
+	backup, err := os.ReadFile(store.path + ".bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(saved), "Second change") || !strings.Contains(string(backup), "First change") {
+		t.Errorf("backup = %q, want the config before the last save", backup)
+	}
+	if string(backup) == string(first) {
+		t.Error("backup holds an older version than the previous one")
+	}
+	assertNoTempFiles(t, filepath.Dir(store.path))
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	if err := writeFileAtomic(path, []byte("first"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte("second"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "second" {
+		t.Errorf("content = %q, %v", data, err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0644 {
+		t.Errorf("mode = %v, want 0644", info.Mode().Perm())
+	}
+	// Only config.yaml is backed up
+	if _, err := os.Stat(path + ".bak"); !os.IsNotExist(err) {
+		t.Error("writeFileAtomic left a backup")
+	}
+
+	// A failed write leaves the target and no partial file behind
+	target := filepath.Join(dir, "target")
+	writeTestFile(t, filepath.Join(target, "keep"), "kept")
+	if err := writeFileAtomic(target, []byte("new"), 0644); err == nil {
+		t.Fatal("replacing a directory succeeded")
+	}
+	if data, err := os.ReadFile(filepath.Join(target, "keep")); err != nil || string(data) != "kept" {
+		t.Errorf("target changed by a failed write: %q, %v", data, err)
+	}
+	assertNoTempFiles(t, dir)
+}
+
+func assertNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp-") {
+			t.Errorf("temporary file %s left behind", entry.Name())
+		}
+	}
+}
+
+// TestScriptAPIConcurrentWrites is meant for go test -race: scripts are
+// created, updated, edited and deleted in parallel through the API.
+func TestScriptAPIConcurrentWrites(t *testing.T) {
+	s := newTestServer(t, "scripts: []\n")
+	admin := s.token("admin", scopeFull)
+
+	const workers = 8
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("script%d", i)
+			steps := []struct {
+				method, path string
+				body         any
+			}{
+				{"POST", "/admin/scripts", map[string]any{"name": name, "description": "Created"}},
+				{"PUT", "/admin/scripts/" + name, map[string]any{"description": "Updated", "tags": []string{"t" + name}}},
+				{"PUT", "/admin/scripts/" + name + "/content", map[string]any{"content": "#!/bin/sh\necho " + name + "\n"}},
+				{"GET", "/admin/scripts", nil},
+			}
+			if i%2 == 0 {
+				steps = append(steps, struct {
+					method, path string
+					body         any
+				}{"DELETE", "/admin/scripts/" + name, nil})
+			}
+			for _, step := range steps {
+				// Saves racing with each other may be refused, but never lost
+				for attempt := 0; ; attempt++ {
+					status, body := s.request(step.method, step.path, admin, step.body)
+					if status == 200 {
+						break
+					}
+					if status != 409 || attempt == 10 {
+						t.Errorf("%s %s: %d %s", step.method, step.path, status, body)
+						return
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// The file on disk holds what the store holds
+	onDisk := newConfigStore(configStore.path)
+	if err := onDisk.Load(); err != nil {
+		t.Fatal(err)
+	}
+	scripts := onDisk.Scripts()
+	if len(scripts) != workers/2 {
+		t.Fatalf("%d scripts saved, want %d: %+v", len(scripts), workers/2, scripts)
+	}
+	for _, script := range scripts {
+		if script.Description != "Updated" || len(script.Tags) != 1 || script.Tags[0] != "t"+script.Name {
+			t.Errorf("script %s = %+v, want its update", script.Name, script)
+		}
+		content, err := os.ReadFile(localScriptFile(script))
+		if err != nil || !strings.Contains(string(content), "echo "+script.Name) {
+			t.Errorf("content of %s = %q, %v", script.Name, content, err)
+		}
+	}
+	if len(configStore.Scripts()) != len(scripts) {
+		t.Error("store and config file disagree")
+	}
+
+	// Script writes leave no backups next to the served files
+	filepath.Walk(scriptsPath, func(path string, info os.FileInfo, err error) error {
+		if err == nil && (strings.HasSuffix(path, ".bak") || strings.Contains(path, ".tmp-")) {
+			t.Errorf("%s left in the scripts directory", path)
+		}
+		return nil
+	})
+}
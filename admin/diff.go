@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const diffContextLines = 3
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// maxDiffCells bounds the LCS table of a diff. Changes with more lines than
+// fit are shown as the old lines removed and the new lines added.
+const maxDiffCells = 4 << 20
+
+// diffLines computes a line-level edit script turning a into b using the
+// longest common subsequence. Lines shared at the start and end are matched
+// first so that the table only covers the changed middle.
+func diffLines(a, b []string) []diffOp {
+	var ops []diffOp
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		ops = append(ops, diffOp{' ', a[prefix]})
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops = append(ops, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+func diffMiddle(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	var ops []diffOp
+	if n == 0 || m == 0 || (n+1)*(m+1) > maxDiffCells {
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+		return ops
+	}
+
+	// lcs[i*(m+1)+j] is the length of the LCS of a[i:] and b[j:]
+	w := m + 1
+	lcs := make([]int32, (n+1)*w)
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+			} else if lcs[(i+1)*w+j] >= lcs[i*w+j+1] {
+				lcs[i*w+j] = lcs[(i+1)*w+j]
+			} else {
+				lcs[i*w+j] = lcs[i*w+j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[(i+1)*w+j] >= lcs[i*w+j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// unifiedDiff renders the differences between a and b in unified diff format.
+// It returns an empty string when the contents are identical.
+func unifiedDiff(fromName, toName, a, b string) string {
+	if a == b {
+		return ""
+	}
+
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	for start := 0; start < len(ops); {
+		// Find the next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+
+		// Extend the hunk until a run of unchanged lines is long enough to split on
+		hunkStart := max(start-diffContextLines, 0)
+		end := start
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContextLines {
+				end = min(end+diffContextLines, len(ops))
+				break
+			}
+			end = run
+		}
+
+		// Line numbers of the hunk in both files
+		aLine, bLine := 1, 1
+		for _, op := range ops[:hunkStart] {
+			if op.kind != '+' {
+				aLine++
+			}
+			if op.kind != '-' {
+				bLine++
+			}
+		}
+		aCount, bCount := 0, 0
+		for _, op := range ops[hunkStart:end] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		if aCount == 0 {
+			aLine--
+		}
+		if bCount == 0 {
+			bLine--
+		}
+
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aLine, aCount, bLine, bCount)
+		for _, op := range ops[hunkStart:end] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		start = end
+	}
+
+	return out.String()
+}
+
+// diffScriptAPI previews a content change. POST compares the live script with
+// the proposed content in the body; GET compares two stored revisions
+// (?from=<id>&to=<id>, where either side may be "live" or "draft").
+func diffScriptAPI(c *fiber.Ctx) error {
+	script, ok := findLocalScript(c.Params("name"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Script not found or not local"})
+	}
+
+	var fromName, toName, from, to string
+
+	if c.Method() == fiber.MethodPost {
+		var body struct {
+			Content string `json:"content"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		live, err := os.ReadFile(localScriptFile(script))
+		if err != nil && !os.IsNotExist(err) {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to read script content"})
+		}
+
+		fromName, toName = script.Name+" (live)", script.Name+" (proposed)"
+		from, to = string(live), body.Content
+	} else {
+		fromID, toID := c.Query("from"), c.Query("to", "live")
+		if fromID == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "Query parameter 'from' is required"})
+		}
+
+		var err error
+		if from, err = revisionOrLiveContent(script, fromID); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Revision not found: " + fromID})
+		}
+		if to, err = revisionOrLiveContent(script, toID); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Revision not found: " + toID})
+		}
+		fromName, toName = script.Name+"@"+fromID, script.Name+"@"+toID
+	}
+
+	diff := unifiedDiff(fromName, toName, from, to)
+	return c.JSON(fiber.Map{
+		"diff":    diff,
+		"changed": diff != "",
+	})
+}
+
+func revisionOrLiveContent(script ScriptConfig, id string) (string, error) {
+	if id == "live" {
+		content, err := os.ReadFile(localScriptFile(script))
+		return string(content), err
+	}
+	if id == "draft" {
+		_, content, err := loadDraft(script.Name)
+		return string(content), err
+	}
+	content, err := loadRevisionContent(script.Name, id)
+	return string(content), err
+}
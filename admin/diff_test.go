@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// opString renders an edit script compactly, e.g. " a|-b|+c"
+func opString(ops []diffOp) string {
+	var parts []string
+	for _, op := range ops {
+		parts = append(parts, string(op.kind)+strings.TrimSuffix(op.line, "\n"))
+	}
+	return strings.Join(parts, "|")
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name, a, b, want string
+	}{
+		{"identical", "a\nb\n", "a\nb\n", " a| b"},
+		{"change in the middle", "a\nb\nc\n", "a\nx\nc\n", " a|-b|+x| c"},
+		{"insertion", "a\nc\n", "a\nb\nc\n", " a|+b| c"},
+		{"deletion at the end", "a\nb\n", "a\n", " a|-b"},
+		{"from empty", "", "a\nb\n", "+a|+b"},
+		{"to empty", "a\n", "", "-a"},
+		{"common lines between changes", "a\nb\nc\nd\ne\n", "a\nx\nc\ny\ne\n", " a|-b|+x| c|-d|+y| e"},
+		{"repeated lines", "a\na\nb\n", "a\nb\nb\n", " a|-a|+b| b"},
+		{"missing trailing newline", "a\nb", "a\nb\n", " a|-b|+b"},
+	}
+	for _, tt := range tests {
+		if got := opString(diffLines(splitLines(tt.a), splitLines(tt.b))); got != tt.want {
+			t.Errorf("%s: diffLines = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiffLinesTooLargeForTable(t *testing.T) {
+	// The changed middle is too large for the LCS table, so the line they
+	// share is shown as removed and added rather than matched
+	var a, b []string
+	a = append(a, "first\n")
+	b = append(b, "first\n")
+	for i := 0; i < 2100; i++ {
+		a = append(a, fmt.Sprintf("old %d\n", i))
+		b = append(b, fmt.Sprintf("new %d\n", i))
+	}
+	a = append(a, "shared\n", "last\n")
+	b = append(b, "shared\n", "more\n", "last\n")
+	if (len(a)-1)*(len(b)-1) <= maxDiffCells {
+		t.Fatal("test input fits in the table")
+	}
+
+	ops := diffLines(a, b)
+	counts := map[byte]int{}
+	for _, op := range ops {
+		counts[op.kind]++
+	}
+	if counts[' '] != 2 || counts['-'] != 2101 || counts['+'] != 2102 {
+		t.Errorf("ops = %d unchanged, %d removed, %d added; want 2, 2101, 2102", counts[' '], counts['-'], counts['+'])
+	}
+	if opString(ops[:1]) != " first" || opString(ops[len(ops)-1:]) != " last" {
+		t.Errorf("prefix and suffix were not kept: %q ... %q", opString(ops[:1]), opString(ops[len(ops)-1:]))
+	}
+}
+
+func numbered(from, to int, replace map[int]string) string {
+	var out strings.Builder
+	for i := from; i <= to; i++ {
+		if line, ok := replace[i]; ok {
+			out.WriteString(line)
+			continue
+		}
+		fmt.Fprintf(&out, "%d\n", i)
+	}
+	return out.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name, a, b, want string
+	}{
+		{"identical", "a\n", "a\n", ""},
+		{
+			"context is limited to three lines",
+			numbered(1, 10, nil), numbered(1, 10, map[int]string{5: "five\n"}),
+			"--- old\n+++ new\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			"hunks six lines apart are merged",
+			numbered(1, 12, nil), numbered(1, 12, map[int]string{2: "two\n", 9: "nine\n"}),
+			"--- old\n+++ new\n@@ -1,12 +1,12 @@\n 1\n-2\n+two\n 3\n 4\n 5\n 6\n 7\n 8\n-9\n+nine\n 10\n 11\n 12\n",
+		},
+		{
+			"hunks seven lines apart are split",
+			numbered(1, 14, nil), numbered(1, 14, map[int]string{2: "two\n", 10: "ten\n"}),
+			"--- old\n+++ new\n@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n@@ -7,7 +7,7 @@\n 7\n 8\n 9\n-10\n+ten\n 11\n 12\n 13\n",
+		},
+		{
+			"from empty",
+			"", "a\nb\n",
+			"--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			"to empty",
+			"a\n", "",
+			"--- old\n+++ new\n@@ -1,1 +0,0 @@\n-a\n",
+		},
+		{
+			"missing trailing newline",
+			"a\nb", "a\nc",
+			"--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+		{
+			"trailing newline added",
+			"a\nb", "a\nb\n",
+			"--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+	}
+	for _, tt := range tests {
+		if got := unifiedDiff("old", "new", tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: unifiedDiff =\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
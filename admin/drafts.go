@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Content changes to local scripts are saved as drafts. A draft only replaces
+// the served file once a user other than its author approves it; until then
+// the script's status in config.yaml is pending_review.
+
+const (
+	statusPublished     = "published"
+	statusPendingReview = "pending_review"
+)
+
+// reviewEnabled is false when REVIEW_ENABLED=false, for single-user setups
+// where nobody else could approve. Saves then publish immediately.
+var reviewEnabled bool
+
+// Draft describes the unpublished content of a local script
+type Draft struct {
+	Script     string    `json:"script"`
+	Author     string    `json:"author"`
+	Updated    time.Time `json:"updated"`
+	Size       int64     `json:"size"`
+	SHA256     string    `json:"sha256"`
+	RollbackOf string    `json:"rollback_of,omitempty"`
+}
+
+// draftMu serializes changes to drafts, so an approval always publishes the
+// content that was reviewed
+var draftMu sync.Mutex
+
+func draftFile(scriptName string) string {
+	return filepath.Join(dataPath, "drafts", scriptName+".sh")
+}
+
+func draftMetaFile(scriptName string) string {
+	return filepath.Join(dataPath, "drafts", scriptName+".json")
+}
+
+func loadDraft(scriptName string) (*Draft, []byte, error) {
+	data, err := os.ReadFile(draftMetaFile(scriptName))
+	if err != nil {
+		return nil, nil, err
+	}
+	var draft Draft
+	if err := json.Unmarshal(data, &draft); err != nil {
+		return nil, nil, err
+	}
+	content, err := os.ReadFile(draftFile(scriptName))
+	if err != nil {
+		return nil, nil, err
+	}
+	return &draft, content, nil
+}
+
+// saveDraft stores content as the script's draft, replacing any previous
+// draft, and marks the script as pending review
+func saveDraft(script ScriptConfig, content []byte, author, rollbackOf string) (*Draft, error) {
+	draftMu.Lock()
+	defer draftMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(draftFile(script.Name)), 0755); err != nil {
+		return nil, err
+	}
+
+	draft := &Draft{
+		Script:     script.Name,
+		Author:     author,
+		Updated:    time.Now().UTC(),
+		Size:       int64(len(content)),
+		SHA256:     contentHash(content),
+		RollbackOf: rollbackOf,
+	}
+	meta, err := json.MarshalIndent(draft, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeFileAtomic(draftFile(script.Name), content, 0644); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(draftMetaFile(script.Name), meta, 0644); err != nil {
+		return nil, err
+	}
+
+	if err := setScriptStatus(script.Name, statusPendingReview); err != nil {
+		return nil, err
+	}
+	return draft, nil
+}
+
+func removeDraft(scriptName string) {
+	for _, path := range []string{draftFile(scriptName), draftMetaFile(scriptName)} {
+		os.Remove(path)
+	}
+}
+
+// setScriptStatus records a script's publish status in the config, skipping
+// the write when it is unchanged
+func setScriptStatus(name, status string) error {
+	if script, ok := findScript(name); ok && script.Status == status {
+		return nil
+	}
+	return configStore.Update(func(cfg *Config) error {
+		for i := range cfg.Scripts {
+			if cfg.Scripts[i].Name == name {
+				cfg.Scripts[i].Status = status
+				return nil
+			}
+		}
+		return errScriptNotFound
+	})
+}
+
+func draftSaveError(c *fiber.Ctx, scriptName string, err error) error {
+	if err == errConfigChanged {
+		return c.Status(409).JSON(fiber.Map{"error": "Config file changed on disk. Reload and try again."})
+	}
+	log.Printf("Failed to save draft for %s: %v", scriptName, err)
+	return c.Status(500).JSON(fiber.Map{"error": "Failed to save draft"})
+}
+
+// getDraftAPI returns a script's draft with its diff against the live content
+func getDraftAPI(c *fiber.Ctx) error {
+	script, ok := findLocalScript(c.Params("name"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Script not found or not local"})
+	}
+
+	draft, content, err := loadDraft(script.Name)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "No draft pending for this script"})
+	}
+	live, err := os.ReadFile(localScriptFile(script))
+	if err != nil && !os.IsNotExist(err) {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to read script content"})
+	}
+
+	return c.JSON(fiber.Map{
+		"draft":   draft,
+		"content": string(content),
+		"diff":    unifiedDiff(script.Name+" (live)", script.Name+" (draft)", string(live), string(content)),
+	})
+}
+
+// approveDraftAPI publishes a draft. The reviewer must not be its author and
+// may pass the sha256 of the draft they reviewed, which must still match.
+func approveDraftAPI(c *fiber.Ctx) error {
+	script, ok := findLocalScript(c.Params("name"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Script not found or not local"})
+	}
+
+	var body struct {
+		SHA256 string `json:"sha256"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	// The hash ties the approval to the version the reviewer saw
+	if body.SHA256 == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "sha256 of the reviewed draft is required"})
+	}
+
+	draftMu.Lock()
+	defer draftMu.Unlock()
+
+	draft, content, err := loadDraft(script.Name)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "No draft pending for this script"})
+	}
+	if reviewEnabled && draft.Author == currentUser(c) {
+		return c.Status(403).JSON(fiber.Map{"error": "Drafts must be approved by someone other than their author"})
+	}
+	if body.SHA256 != draft.SHA256 {
+		return c.Status(409).JSON(fiber.Map{"error": "Draft changed since it was reviewed. Review the new version and try again."})
+	}
+
+	rev, previousHash, err := writeScriptContent(script, content, draft.Author, draft.RollbackOf, "")
+	if err != nil {
+		log.Printf("Failed to publish draft for %s: %v", script.Name, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to publish draft"})
+	}
+	removeDraft(script.Name)
+	if err := setScriptStatus(script.Name, statusPublished); err != nil {
+		log.Printf("Failed to mark %s as published: %v", script.Name, err)
+	}
+
+	details := "approved draft by " + draft.Author
+	if draft.RollbackOf != "" {
+		details += ", rollback to revision " + draft.RollbackOf
+	}
+	log.Printf("Published draft of %s by %s", script.Name, draft.Author)
+	recordAudit(c, AuditEntry{
+		Action:       "script.publish",
+		Script:       script.Name,
+		BeforeSHA256: previousHash,
+		AfterSHA256:  rev.SHA256,
+		Details:      details,
+	})
+	updateIndexPageWithCurrentScripts()
+
+	return c.JSON(fiber.Map{
+		"message":  "Draft published successfully",
+		"revision": rev,
+	})
+}
+
+// discardDraftAPI rejects a draft, leaving the live content unchanged
+func discardDraftAPI(c *fiber.Ctx) error {
+	script, ok := findLocalScript(c.Params("name"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Script not found or not local"})
+	}
+
+	draftMu.Lock()
+	defer draftMu.Unlock()
+
+	draft, _, err := loadDraft(script.Name)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "No draft pending for this script"})
+	}
+	if err := setScriptStatus(script.Name, statusPublished); err != nil {
+		return draftSaveError(c, script.Name, err)
+	}
+	removeDraft(script.Name)
+
+	recordAudit(c, AuditEntry{
+		Action:  "script.draft_discard",
+		Script:  script.Name,
+		Details: fmt.Sprintf("draft by %s (sha256 %s)", draft.Author, draft.SHA256),
+	})
+
+	return c.JSON(fiber.Map{"message": "Draft discarded"})
+}
+
+// listReviewsAPI returns the drafts waiting for review, oldest first
+func listReviewsAPI(c *fiber.Ctx) error {
+	drafts := []Draft{}
+	for _, script := range configStore.Scripts() {
+		if script.Status != statusPendingReview {
+			continue
+		}
+		draft, _, err := loadDraft(script.Name)
+		if err != nil {
+			log.Printf("Script %s is pending review but its draft cannot be read: %v", script.Name, err)
+			continue
+		}
+		drafts = append(drafts, *draft)
+	}
+
+	sort.Slice(drafts, func(i, j int) bool {
+		return drafts[i].Updated.Before(drafts[j].Updated)
+	})
+	return c.JSON(drafts)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApproveDraft(t *testing.T) {
+	s := newTestServer(t, "scripts:\n  - name: hello\n    type: local\n")
+	swapGlobal(t, &reviewEnabled, true)
+	live := "#!/bin/bash\nset -e\necho live\n"
+	s.writeScript("hello", live)
+	editor := s.token("editor", scopeFull)
+	admin := s.token("admin", scopeFull)
+
+	proposed := "#!/bin/bash\nset -e\necho proposed\n"
+	status, body := s.request("PUT", "/admin/scripts/hello/content", editor, map[string]string{"content": proposed})
+	if status != 200 {
+		t.Fatalf("save draft = %d %s", status, body)
+	}
+	var saved struct{ Draft Draft }
+	decodeJSON(t, body, &saved)
+	if saved.Draft.SHA256 != contentHash([]byte(proposed)) || saved.Draft.Author != "editor" {
+		t.Fatalf("draft = %+v", saved.Draft)
+	}
+	if script, _ := findScript("hello"); script.Status != statusPendingReview {
+		t.Errorf("status = %q, want %q", script.Status, statusPendingReview)
+	}
+
+	approve := "/admin/scripts/hello/draft/approve"
+	tests := []struct {
+		name   string
+		secret string
+		body   any
+		want   int
+	}{
+		{"without a body", admin, nil, 400},
+		{"without sha256", admin, map[string]string{}, 400},
+		{"by its author", editor, map[string]string{"sha256": saved.Draft.SHA256}, 403},
+		{"of another version", admin, map[string]string{"sha256": contentHash([]byte(live))}, 409},
+	}
+	for _, tt := range tests {
+		if status, body := s.request("POST", approve, tt.secret, tt.body); status != tt.want {
+			t.Errorf("approve %s = %d %s, want %d", tt.name, status, body, tt.want)
+		}
+	}
+	if content, _ := os.ReadFile(filepath.Join(scriptsPath, "hello.sh")); string(content) != live {
+		t.Fatalf("refused approval changed the live script to %q", content)
+	}
+
+	status, body = s.request("POST", approve, admin, map[string]string{"sha256": saved.Draft.SHA256})
+	if status != 200 {
+		t.Fatalf("approve = %d %s", status, body)
+	}
+	var published struct{ Revision Revision }
+	decodeJSON(t, body, &published)
+	if published.Revision.Author != "editor" || published.Revision.SHA256 != saved.Draft.SHA256 {
+		t.Errorf("revision = %+v, want one by editor of the draft", published.Revision)
+	}
+	if content, _ := os.ReadFile(filepath.Join(scriptsPath, "hello.sh")); string(content) != proposed {
+		t.Errorf("live script = %q, want the draft", content)
+	}
+	if script, _ := findScript("hello"); script.Status != statusPublished {
+		t.Errorf("status = %q, want %q", script.Status, statusPublished)
+	}
+	if status, _ := s.request("GET", "/admin/scripts/hello/draft", admin, nil); status != 404 {
+		t.Errorf("draft after approval = %d, want 404", status)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The update feeds are Atom views of the audit log, limited to changes
+// consumers of the scripts can see. They name no users or addresses.
+
+const feedSize = 50
+
+// feedActions maps audit actions that change a public script to how the
+// change is described in the feed
+var feedActions = map[string]string{
+	"script.create":   "added",
+	"script.update":   "details updated",
+	"script.delete":   "removed",
+	"script.content":  "updated",
+	"script.publish":  "updated",
+	"script.rollback": "rolled back",
+	"script.sync":     "updated from git",
+	"mirror.changed":  "updated upstream",
+	"mirror.accept":   "updated upstream",
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomPerson  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID       string       `xml:"id"`
+	Title    string       `xml:"title"`
+	Updated  string       `xml:"updated"`
+	Category *atomTerm    `xml:"category,omitempty"`
+	Links    []atomLink   `xml:"link"`
+	Content  *atomContent `xml:"content,omitempty"`
+}
+
+type atomTerm struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// maxFeedSummaries bounds the change summaries kept between requests
+const maxFeedSummaries = 1000
+
+// feedCache keeps the public changes read from the audit log and the line
+// counts of content changes, so that feed requests only re-read the log
+// after it has been written to and only diff each change once.
+type feedCache struct {
+	mu      sync.Mutex
+	loaded  bool
+	size    int64
+	modTime time.Time
+	// changes are all public changes, newest first
+	changes   []AuditEntry
+	summaries map[string]string
+}
+
+var feeds = &feedCache{summaries: map[string]string{}}
+
+// feedEntries returns the newest public changes, of one script if name is
+// set, newest first
+func feedEntries(name string) ([]AuditEntry, error) {
+	return feeds.entries(name)
+}
+
+func (f *feedCache) entries(name string) ([]AuditEntry, error) {
+	// The log is appended to and rotated, so its size and modification time
+	// change with every entry
+	var size int64
+	var modTime time.Time
+	if info, err := os.Stat(auditLogPath()); err == nil {
+		size, modTime = info.Size(), info.ModTime()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.loaded || size != f.size || !modTime.Equal(f.modTime) {
+		changes, err := publicChanges()
+		if err != nil {
+			return nil, err
+		}
+		f.changes, f.size, f.modTime, f.loaded = changes, size, modTime, true
+	}
+
+	var changes []AuditEntry
+	for _, entry := range f.changes {
+		if name != "" && entry.Script != name {
+			continue
+		}
+		changes = append(changes, entry)
+		if len(changes) == feedSize {
+			break
+		}
+	}
+	return changes, nil
+}
+
+// publicChanges reads the audit entries that belong in the feeds
+func publicChanges() ([]AuditEntry, error) {
+	entries, err := readAudit(auditFilter{}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []AuditEntry
+	for _, entry := range entries {
+		if _, ok := feedActions[entry.Action]; !ok || entry.Script == "" {
+			continue
+		}
+		if entry.Action == "mirror.changed" && entry.Details == mirrorChangeHeld {
+			continue
+		}
+		changes = append(changes, entry)
+	}
+	return changes, nil
+}
+
+// summary returns the change summary of an entry, computing it once. It only
+// depends on the hashes, whose revisions never change.
+func (f *feedCache) summary(entry AuditEntry) string {
+	key := entry.Script + " " + entry.BeforeSHA256 + " " + entry.AfterSHA256
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	summary, ok := f.summaries[key]
+	if !ok {
+		if len(f.summaries) >= maxFeedSummaries {
+			f.summaries = map[string]string{}
+		}
+		summary = contentChangeSummary(entry)
+		f.summaries[key] = summary
+	}
+	return summary
+}
+
+// feedEntry renders one change. The description is that of the script at the
+// time of the change where the audit entry has it, else the current one.
+func feedEntry(base string, entry AuditEntry) atomEntry {
+	var script ScriptConfig
+	switch {
+	case entry.After != nil:
+		script = *entry.After
+	case entry.Before != nil:
+		script = *entry.Before
+	default:
+		script, _ = findScript(entry.Script)
+	}
+
+	var body strings.Builder
+	if script.Description != "" {
+		body.WriteString(script.Description + "\n\n")
+	}
+	if entry.AfterSHA256 != "" {
+		body.WriteString("SHA-256: " + entry.AfterSHA256 + "\n")
+	}
+	if summary := feeds.summary(entry); summary != "" {
+		body.WriteString("Changes: " + summary + "\n")
+	}
+	if entry.Action == "script.delete" {
+		body.WriteString("This script is no longer available.\n")
+	}
+
+	out := atomEntry{
+		ID:      fmt.Sprintf("tag:script-admin,%s:%s/%d", entry.Time.Format("2006-01-02"), entry.Script, entry.Time.UnixNano()),
+		Title:   entry.Script + " " + feedActions[entry.Action],
+		Updated: entry.Time.Format(time.RFC3339),
+		Content: &atomContent{Type: "text", Body: strings.TrimSpace(body.String())},
+	}
+	if script.Category != "" {
+		out.Category = &atomTerm{Term: script.Category}
+	}
+	if entry.Action != "script.delete" {
+		out.Links = []atomLink{{Rel: "alternate", Href: base + "/" + entry.Script}}
+	}
+	return out
+}
+
+// contentChangeSummary counts the lines added and removed by a content
+// change, when both versions are still in the script's history
+func contentChangeSummary(entry AuditEntry) string {
+	if entry.AfterSHA256 == "" || entry.BeforeSHA256 == entry.AfterSHA256 {
+		return ""
+	}
+	after, ok := revisionContentBySHA(entry.Script, entry.AfterSHA256)
+	if !ok {
+		return ""
+	}
+	var before []byte
+	if entry.BeforeSHA256 != "" {
+		if before, ok = revisionContentBySHA(entry.Script, entry.BeforeSHA256); !ok {
+			return ""
+		}
+	}
+
+	added, removed := 0, 0
+	for _, op := range diffLines(splitLines(string(before)), splitLines(string(after))) {
+		switch op.kind {
+		case '+':
+			added++
+		case '-':
+			removed++
+		}
+	}
+	return fmt.Sprintf("%d lines added, %d removed", added, removed)
+}
+
+// revisionContentBySHA finds content with the given hash in a script's history
+func revisionContentBySHA(scriptName, sha256 string) ([]byte, bool) {
+	revisions, err := listRevisions(scriptName)
+	if err != nil {
+		return nil, false
+	}
+	for _, rev := range revisions {
+		if rev.SHA256 != sha256 {
+			continue
+		}
+		content, err := loadRevisionContent(scriptName, rev.ID)
+		return content, err == nil
+	}
+	return nil, false
+}
+
+func sendFeed(c *fiber.Ctx, name, title, selfPath string) error {
+	entries, err := feedEntries(name)
+	if err != nil {
+		log.Printf("Failed to read audit log for feed: %v", err)
+		return c.Status(500).SendString("Failed to build feed")
+	}
+
+	base := publicBaseURL(c)
+	feed := atomFeed{
+		ID:     base + selfPath,
+		Title:  title,
+		Author: atomPerson{Name: "Script Server"},
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: base + selfPath},
+			{Rel: "alternate", Type: "text/html", Href: base + "/"},
+		},
+	}
+	// An empty feed has not changed since the epoch rather than since now,
+	// which would change its ETag on every request
+	feed.Updated = time.Unix(0, 0).UTC().Format(time.RFC3339)
+	if len(entries) > 0 {
+		feed.Updated = entries[0].Time.Format(time.RFC3339)
+	}
+	for _, entry := range entries {
+		feed.Entries = append(feed.Entries, feedEntry(base, entry))
+	}
+
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode feed: %v", err)
+		return c.Status(500).SendString("Failed to build feed")
+	}
+	return sendGenerated(c, append([]byte(xml.Header), data...), "application/atom+xml; charset=utf-8")
+}
+
+// feedHandler serves the changes to all scripts
+func feedHandler(c *fiber.Ctx) error {
+	return sendFeed(c, "", "Script updates", "/feed.atom")
+}
+
+// scriptFeedHandler serves the changes to one script. Removed scripts keep
+// their feed, which ends with the removal.
+func scriptFeedHandler(c *fiber.Ctx) error {
+	name := strings.TrimSuffix(c.Params("name"), ".sh")
+	if script, ok := findScript(name); ok {
+		name = script.Name
+	} else if entries, err := feedEntries(name); err != nil || len(entries) == 0 {
+		c.Set(fiber.HeaderContentType, "text/plain")
+		return c.Status(404).SendString("Script not found")
+	}
+	return sendFeed(c, name, name+" updates", "/feed/"+name+".atom")
+}
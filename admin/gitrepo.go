@@ -0,0 +1,570 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// With GIT_REPO_PATH set, the scripts directory and config.yaml live in a git
+// work tree and every change made through the admin API is committed there,
+// authored by the acting user. With GIT_REMOTE set as well, the repository is
+// pulled from and pushed to that remote, which makes it the source of truth.
+
+// GitRepo runs git commands against the work tree holding the scripts
+type GitRepo struct {
+	mu     sync.Mutex
+	dir    string
+	remote string
+	branch string
+	// paths are the scripts directory and config file, relative to dir
+	paths []string
+
+	pushRequests chan struct{}
+
+	lastSync  time.Time
+	lastError string
+}
+
+// gitRepo is nil unless the git backend is enabled
+var gitRepo *GitRepo
+
+// GitStatus is the state of the git backend reported by /admin/git
+type GitStatus struct {
+	Path      string     `json:"path"`
+	Remote    string     `json:"remote,omitempty"`
+	Branch    string     `json:"branch"`
+	Head      string     `json:"head"`
+	LastSync  *time.Time `json:"last_sync,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// initGit opens or creates the repository configured by GIT_REPO_PATH. It
+// must run before the config is loaded, since the remote wins over local
+// files when the local repository has no history yet.
+func initGit(configPath string) error {
+	dir := os.Getenv("GIT_REPO_PATH")
+	if dir == "" {
+		return nil
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	branch := os.Getenv("GIT_BRANCH")
+	if branch == "" {
+		branch = "main"
+	}
+	var paths []string
+	for _, path := range []string{scriptsPath, configPath} {
+		rel, err := repoRelative(dir, path)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, rel)
+	}
+
+	remote := os.Getenv("GIT_REMOTE")
+	if remote != "" {
+		// Refuse before the import commit puts them in the history
+		if err := checkPushableConfig(configPath); err != nil {
+			return err
+		}
+	}
+	repo, err := openGitRepo(dir, remote, branch, paths)
+	if err != nil {
+		return err
+	}
+	gitRepo = repo
+	log.Printf("Git backend enabled at %s (branch %s)", dir, repo.branch)
+	return nil
+}
+
+// openGitRepo opens or creates the repository in dir tracking paths
+func openGitRepo(dir, remote, branch string, paths []string) (*GitRepo, error) {
+	repo := &GitRepo{
+		dir:          dir,
+		remote:       remote,
+		branch:       branch,
+		paths:        paths,
+		pushRequests: make(chan struct{}, 1),
+	}
+	if err := repo.open(); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// checkPushableConfig refuses a config file with inline webhook secrets,
+// which would be pushed to the remote along with the rest of config.yaml
+func checkPushableConfig(configPath string) error {
+	data, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return err
+	}
+	return checkInlineSecrets(cfg.Webhooks)
+}
+
+// checkInlineSecrets refuses webhooks whose secret is in config.yaml while
+// the file is pushed to a git remote
+func checkInlineSecrets(webhooks []Webhook) error {
+	for _, webhook := range webhooks {
+		if webhook.Secret != "" {
+			return fmt.Errorf("webhook %q has an inline secret, which would be pushed to GIT_REMOTE; use secret_env", webhook.Name)
+		}
+	}
+	return nil
+}
+
+// repoRelative returns path relative to the work tree, which must contain it
+func repoRelative(dir, path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("%s is not inside GIT_REPO_PATH %s", path, dir)
+	}
+	return rel, nil
+}
+
+func (g *GitRepo) open() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, err := os.Stat(filepath.Join(g.dir, ".git")); os.IsNotExist(err) {
+		if _, err := g.run("init"); err != nil {
+			return err
+		}
+		if _, err := g.run("symbolic-ref", "HEAD", "refs/heads/"+g.branch); err != nil {
+			return err
+		}
+		log.Printf("Initialized git repository in %s", g.dir)
+	}
+	if err := g.writeIgnoreFile(); err != nil {
+		return err
+	}
+
+	if g.remote != "" {
+		if _, err := g.run("remote", "get-url", "origin"); err != nil {
+			_, err = g.run("remote", "add", "origin", g.remote)
+			if err != nil {
+				return err
+			}
+		} else if _, err := g.run("remote", "set-url", "origin", g.remote); err != nil {
+			return err
+		}
+
+		// A fresh repository takes its content from the remote
+		if _, err := g.run("rev-parse", "--verify", "-q", "HEAD"); err != nil {
+			if _, err := g.run("fetch", "origin", g.branch); err == nil {
+				if _, err := g.run("reset", "--hard", "origin/"+g.branch); err != nil {
+					return err
+				}
+				log.Printf("Checked out %s from %s", g.branch, g.remote)
+			}
+		}
+	}
+
+	if err := g.commit("Import existing scripts and config", "Script Admin", "", g.paths); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeIgnoreFile keeps generated files and backups out of the repository
+func (g *GitRepo) writeIgnoreFile() error {
+	ignorePath := filepath.Join(g.dir, ".gitignore")
+	existing, _ := os.ReadFile(ignorePath)
+
+	rules := []string{"*.bak", ".*.tmp-*", "/" + filepath.ToSlash(filepath.Join(g.paths[0], "index.html"))}
+	var missing []string
+	for _, rule := range rules {
+		if !strings.Contains("\n"+string(existing)+"\n", "\n"+rule+"\n") {
+			missing = append(missing, rule)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	content := string(existing)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	content += strings.Join(missing, "\n") + "\n"
+	return os.WriteFile(ignorePath, []byte(content), 0644)
+}
+
+// run executes a git command in the work tree and returns its trimmed output
+func (g *GitRepo) run(args ...string) (string, error) {
+	return runGit(g.dir, args...)
+}
+
+// runGit executes a git command in dir and returns its trimmed output
+func runGit(dir string, args ...string) (string, error) {
+	out, err := runGitOutput(dir, args...)
+	return strings.TrimSpace(string(out)), err
+}
+
+// runGitOutput executes a git command in dir and returns its exact output
+func runGitOutput(dir string, args ...string) ([]byte, error) {
+	// Repositories are often bind mounts owned by another user
+	cmd := exec.Command("git", append([]string{"-c", "safe.directory=" + dir, "-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()+string(out)))
+	}
+	return out, nil
+}
+
+// gitCommitter sets the committer of commits the server makes or rebases,
+// which may run where git has no identity configured
+var gitCommitter = []string{"-c", "user.name=Script Admin", "-c", "user.email=script-admin@script-admin"}
+
+// commit stages the given paths, relative to the work tree, and commits them
+// if any changed. Changes to other files are left for their own commits. The
+// caller must hold g.mu.
+func (g *GitRepo) commit(message, authorName, details string, paths []string) error {
+	var changed []string
+	for _, path := range append([]string{".gitignore"}, paths...) {
+		// Paths that are gone must have been tracked to be staged as removed
+		if _, err := os.Lstat(filepath.Join(g.dir, path)); err != nil {
+			if tracked, _ := g.run("ls-files", "--", path); tracked == "" {
+				continue
+			}
+		}
+		changed = append(changed, path)
+	}
+	if _, err := g.run(append([]string{"add", "-A", "--"}, changed...)...); err != nil {
+		return err
+	}
+	if _, err := g.run(append([]string{"diff", "--cached", "--quiet", "--"}, changed...)...); err == nil {
+		return nil
+	}
+
+	if details != "" {
+		message += "\n\n" + details
+	}
+	author := fmt.Sprintf("%s <%s@script-admin>", authorName, strings.ToLower(strings.ReplaceAll(authorName, " ", "-")))
+	args := append(gitCommitter, "commit", "-q", "-m", message, "--author", author, "--")
+	if _, err := g.run(append(args, changed...)...); err != nil {
+		return err
+	}
+
+	if g.remote != "" {
+		select {
+		case g.pushRequests <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// CommitChange commits the files changed by an audited change, authored by
+// the acting user. It runs in the request that made the change and commits
+// only the files of that change, so that other users' changes are not
+// committed under its name.
+func (g *GitRepo) CommitChange(entry AuditEntry) {
+	message := commitMessage(entry)
+	if message == "" {
+		return
+	}
+
+	var details []string
+	if entry.Details != "" {
+		details = append(details, entry.Details)
+	}
+	if entry.TokenID != "" {
+		details = append(details, "Via API token "+entry.TokenID)
+	}
+
+	author := entry.Actor
+	if author == "" {
+		author = "unknown"
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.commit(message, author, strings.Join(details, "\n"), g.entryPaths(entry)); err != nil {
+		log.Printf("Failed to commit %s: %v", entry.Action, err)
+		g.lastError = err.Error()
+	}
+}
+
+// configActions are the audit actions that change config.yaml
+var configActions = map[string]bool{
+	"script.create":        true,
+	"script.update":        true,
+	"script.delete":        true,
+	"script.draft":         true,
+	"script.publish":       true,
+	"script.draft_discard": true,
+	"user.create":          true,
+	"user.update":          true,
+	"user.password":        true,
+}
+
+// entryPaths returns the paths, relative to the work tree, that an audited
+// change may have written: the script's files and, for changes to settings,
+// config.yaml
+func (g *GitRepo) entryPaths(entry AuditEntry) []string {
+	var paths []string
+	if entry.Script != "" {
+		for _, name := range []string{entry.Script, entry.Script + "_dir", entry.Script + ".sh"} {
+			paths = append(paths, filepath.Join(g.paths[0], name))
+		}
+		// A linked file may live anywhere in the scripts directory
+		scripts := []*ScriptConfig{entry.Before, entry.After}
+		if script, ok := findScript(entry.Script); ok {
+			scripts = append(scripts, &script)
+		}
+		for _, script := range scripts {
+			if script == nil || script.ScriptPath == "" {
+				continue
+			}
+			if rel, err := repoRelative(g.dir, script.ScriptPath); err == nil && !slices.Contains(paths, rel) {
+				paths = append(paths, rel)
+			}
+		}
+	}
+	if configActions[entry.Action] {
+		paths = append(paths, g.paths[1])
+	}
+	return paths
+}
+
+// commitMessage describes an audit action as a commit subject. Actions that
+// do not touch the repository get none.
+func commitMessage(entry AuditEntry) string {
+	switch entry.Action {
+	case "script.create":
+		return "Create script " + entry.Script
+	case "script.update":
+		return "Update settings of " + entry.Script
+	case "script.delete":
+		return "Delete script " + entry.Script
+	case "script.content":
+		return "Update content of " + entry.Script
+	case "script.rollback":
+		return "Roll back " + entry.Script
+	case "script.draft":
+		return "Submit draft of " + entry.Script + " for review"
+	case "script.publish":
+		return "Publish " + entry.Script
+	case "script.draft_discard":
+		return "Discard draft of " + entry.Script
+	case "script.sync":
+		return "Sync " + entry.Script + " from its git source"
+	case "user.create":
+		return "Create user"
+	case "user.update":
+		return "Update user"
+	case "user.password":
+		return "Change user password"
+	}
+	return ""
+}
+
+// gitSyncInterval reads GIT_SYNC_INTERVAL (e.g. 30s, 5m), defaulting to a
+// minute
+func gitSyncInterval() time.Duration {
+	if interval, err := time.ParseDuration(os.Getenv("GIT_SYNC_INTERVAL")); err == nil && interval > 0 {
+		return interval
+	}
+	return time.Minute
+}
+
+// Run pulls from and pushes to the remote every interval, and pushes as soon
+// as a change is committed
+func (g *GitRepo) Run(interval time.Duration) {
+	if g.remote == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			g.Sync()
+		case <-g.pushRequests:
+			g.Sync()
+		}
+	}
+}
+
+// Sync pulls remote changes, applies them and pushes local commits
+func (g *GitRepo) Sync() {
+	pulled, err := g.sync()
+
+	g.mu.Lock()
+	g.lastSync = time.Now().UTC()
+	g.lastError = ""
+	if err != nil {
+		g.lastError = err.Error()
+	}
+	g.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Git sync with %s failed: %v", g.remote, err)
+	}
+	if pulled {
+		applyPulledChanges()
+	}
+}
+
+func (g *GitRepo) sync() (bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	before, _ := g.run("rev-parse", "--verify", "-q", "HEAD")
+
+	if _, err := g.run("fetch", "origin", g.branch); err != nil {
+		// The remote branch does not exist until the first push
+		if _, lsErr := g.run("ls-remote", "--exit-code", "--heads", "origin", g.branch); lsErr == nil {
+			return false, err
+		}
+	} else if err := g.rebase(); err != nil {
+		return false, err
+	}
+
+	after, _ := g.run("rev-parse", "--verify", "-q", "HEAD")
+	if after == "" {
+		return false, nil
+	}
+	if _, err := g.run("push", "-q", "origin", "HEAD:refs/heads/"+g.branch); err != nil {
+		return before != after, err
+	}
+	return before != after, nil
+}
+
+// rebase replays local commits onto the fetched remote branch. Neither script
+// content nor the config is written while the work tree is being rewritten.
+func (g *GitRepo) rebase() error {
+	contentMu.Lock()
+	defer contentMu.Unlock()
+
+	return configStore.Exclusive(func() error {
+		if _, err := g.run(append(gitCommitter, "rebase", "--autostash", "origin/"+g.branch)...); err != nil {
+			g.run("rebase", "--abort")
+			return rebaseConflict(err)
+		}
+		return nil
+	})
+}
+
+// rebaseConflict summarises a failed rebase by its CONFLICT lines
+func rebaseConflict(err error) error {
+	var conflicts []string
+	for _, line := range strings.Split(err.Error(), "\n") {
+		if strings.HasPrefix(line, "CONFLICT") {
+			conflicts = append(conflicts, line)
+		}
+	}
+	if len(conflicts) == 0 {
+		return fmt.Errorf("rebase onto the remote failed: %v", err)
+	}
+	return errors.New("local commits conflict with the remote, resolve them in the repository: " + strings.Join(conflicts, "; "))
+}
+
+// applyPulledChanges reloads the config and records pulled script content as
+// revisions, signs it and regenerates the index page
+func applyPulledChanges() {
+	changed, err := configStore.Reload()
+	if err != nil {
+		log.Printf("Pulled config is invalid, keeping the current one: %v", err)
+	}
+
+	contentMu.Lock()
+	for _, script := range configStore.Scripts() {
+		if script.Type != "local" {
+			continue
+		}
+		content, err := os.ReadFile(localScriptFile(script))
+		if err != nil {
+			continue
+		}
+		if revisions, err := listRevisions(script.Name); err == nil && len(revisions) > 0 &&
+			revisions[0].SHA256 == contentHash(content) {
+			continue
+		}
+		if _, err := saveRevision(script.Name, content, "git", "", ""); err != nil {
+			log.Printf("Failed to record pulled revision of %s: %v", script.Name, err)
+		}
+		if err := signScript(script.Name, content); err != nil {
+			log.Printf("Failed to sign %s: %v", script.Name, err)
+		}
+		log.Printf("Pulled new content for %s", script.Name)
+	}
+	contentMu.Unlock()
+
+	updateIndexPageWithCurrentScripts()
+	if changed {
+		if err := syncCaddyRedirects(configStore.Scripts()); err != nil {
+			log.Printf("Failed to sync redirect routes with Caddy: %v", err)
+		}
+	}
+}
+
+// Status reports the repository head and the outcome of the last sync
+func (g *GitRepo) Status() GitStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	status := GitStatus{
+		Path:      g.dir,
+		Remote:    g.remote,
+		Branch:    g.branch,
+		LastError: g.lastError,
+	}
+	status.Head, _ = g.run("rev-parse", "--verify", "-q", "HEAD")
+	if !g.lastSync.IsZero() {
+		lastSync := g.lastSync
+		status.LastSync = &lastSync
+	}
+	return status
+}
+
+func gitStatusAPI(c *fiber.Ctx) error {
+	if gitRepo == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Git backend is not enabled"})
+	}
+	return c.JSON(gitRepo.Status())
+}
+
+// gitSyncAPI pulls and pushes immediately instead of waiting for the next
+// interval
+func gitSyncAPI(c *fiber.Ctx) error {
+	if gitRepo == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Git backend is not enabled"})
+	}
+	if gitRepo.remote == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "No GIT_REMOTE configured"})
+	}
+	gitRepo.Sync()
+	return c.JSON(gitRepo.Status())
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestGitRepo creates a work tree with a scripts directory and config
+// file, backed by a bare remote that starts out empty
+func newTestGitRepo(t *testing.T) (*GitRepo, string) {
+	t.Helper()
+	base := t.TempDir()
+	remote := filepath.Join(base, "remote.git")
+	if _, err := runGit(base, "init", "-q", "--bare", remote); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(base, "work")
+	writeTestFile(t, filepath.Join(dir, "scripts", "docker.sh"), "#!/bin/sh\necho docker\n")
+	writeTestFile(t, filepath.Join(dir, "config.yaml"), "scripts: []\n")
+
+	swapGlobal(t, &configStore, newConfigStore(filepath.Join(dir, "config.yaml")))
+	repo, err := openGitRepo(dir, remote, "main", []string{"scripts", "config.yaml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo, remote
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func gitOutput(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := runGit(dir, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// cloneAndPush commits content to path in a separate clone of remote and
+// pushes it, as another user of the repository would
+func cloneAndPush(t *testing.T, remote, path, content string) {
+	t.Helper()
+	clone := filepath.Join(t.TempDir(), "clone")
+	if _, err := runGit(filepath.Dir(clone), "clone", "-q", "-b", "main", remote, clone); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(clone, path), content)
+	gitOutput(t, clone, "-c", "user.name=Bob", "-c", "user.email=bob@example.com", "commit", "-qam", "Remote change")
+	gitOutput(t, clone, "push", "-q", "origin", "main")
+}
+
+func TestGitRepoCommitAndPush(t *testing.T) {
+	repo, remote := newTestGitRepo(t)
+
+	if subject := gitOutput(t, repo.dir, "log", "-1", "--format=%s"); subject != "Import existing scripts and config" {
+		t.Fatalf("initial commit = %q", subject)
+	}
+
+	writeTestFile(t, filepath.Join(repo.dir, "scripts", "docker.sh"), "#!/bin/sh\necho updated\n")
+	repo.CommitChange(AuditEntry{Actor: "alice", Action: "script.content", Script: "docker", TokenID: "3f9c2a1b7d4e"})
+
+	if got := gitOutput(t, repo.dir, "log", "-1", "--format=%an|%s|%b"); got != "alice|Update content of docker|Via API token 3f9c2a1b7d4e" {
+		t.Errorf("commit = %q", got)
+	}
+
+	// Actions that change nothing in the repository are not committed
+	head := gitOutput(t, repo.dir, "rev-parse", "HEAD")
+	repo.CommitChange(AuditEntry{Actor: "alice", Action: "script.content", Script: "docker"})
+	if got := gitOutput(t, repo.dir, "rev-parse", "HEAD"); got != head {
+		t.Error("committed without changes")
+	}
+
+	pulled, err := repo.sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pulled {
+		t.Error("sync reported pulled changes from an empty remote")
+	}
+	if got := gitOutput(t, remote, "rev-parse", "main"); got != head {
+		t.Errorf("remote main = %s, want %s", got, head)
+	}
+}
+
+func TestGitRepoCommitsOnlyChangedFiles(t *testing.T) {
+	repo, _ := newTestGitRepo(t)
+
+	// Another user's change, not yet committed, is left out of alice's commit
+	writeTestFile(t, filepath.Join(repo.dir, "scripts", "k3s.sh"), "#!/bin/sh\necho k3s\n")
+	writeTestFile(t, filepath.Join(repo.dir, "config.yaml"), "scripts: [{name: k3s}]\n")
+	writeTestFile(t, filepath.Join(repo.dir, "scripts", "docker.sh"), "#!/bin/sh\necho updated\n")
+	repo.CommitChange(AuditEntry{Actor: "alice", Action: "script.content", Script: "docker"})
+
+	if got := gitOutput(t, repo.dir, "show", "--format=%an", "--name-only", "HEAD"); got != "alice\n\nscripts/docker.sh" {
+		t.Errorf("alice's commit = %q", got)
+	}
+	if got := gitOutput(t, repo.dir, "status", "--porcelain"); got != "M config.yaml\n?? scripts/k3s.sh" {
+		t.Errorf("status = %q", got)
+	}
+
+	repo.CommitChange(AuditEntry{Actor: "bob", Action: "script.create", Script: "k3s"})
+	if got := gitOutput(t, repo.dir, "show", "--format=%an", "--name-only", "HEAD"); got != "bob\n\nconfig.yaml\nscripts/k3s.sh" {
+		t.Errorf("bob's commit = %q", got)
+	}
+}
+
+func TestCheckPushableConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeTestFile(t, path, "webhooks:\n  - name: ci\n    url: https://ci.example.com/hook\n    secret_env: CI_SECRET\n")
+	if err := checkPushableConfig(path); err != nil {
+		t.Errorf("secret_env refused: %v", err)
+	}
+
+	writeTestFile(t, path, "webhooks:\n  - name: ci\n    url: https://ci.example.com/hook\n    secret: hunter2\n")
+	if err := checkPushableConfig(path); err == nil || !strings.Contains(err.Error(), "inline secret") {
+		t.Errorf("checkPushableConfig() = %v, want an inline secret error", err)
+	}
+}
+
+func TestGitRepoSyncPullsRemoteChanges(t *testing.T) {
+	repo, remote := newTestGitRepo(t)
+	if _, err := repo.sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	cloneAndPush(t, remote, "scripts/docker.sh", "#!/bin/sh\necho from remote\n")
+	writeTestFile(t, filepath.Join(repo.dir, "config.yaml"), "scripts: [{name: docker}]\n")
+	repo.CommitChange(AuditEntry{Actor: "alice", Action: "script.create", Script: "docker"})
+
+	pulled, err := repo.sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pulled {
+		t.Error("sync did not report pulled changes")
+	}
+	content, err := os.ReadFile(filepath.Join(repo.dir, "scripts", "docker.sh"))
+	if err != nil || string(content) != "#!/bin/sh\necho from remote\n" {
+		t.Errorf("docker.sh = %q, %v", content, err)
+	}
+	// The local commit is rebased onto the remote one and pushed
+	if got := gitOutput(t, remote, "log", "-2", "--format=%s", "main"); got != "Create script docker\nRemote change" {
+		t.Errorf("remote log = %q", got)
+	}
+}
+
+func TestGitRepoSyncConflict(t *testing.T) {
+	repo, remote := newTestGitRepo(t)
+	if _, err := repo.sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	cloneAndPush(t, remote, "scripts/docker.sh", "#!/bin/sh\necho from remote\n")
+	writeTestFile(t, filepath.Join(repo.dir, "scripts", "docker.sh"), "#!/bin/sh\necho from admin\n")
+	repo.CommitChange(AuditEntry{Actor: "alice", Action: "script.content", Script: "docker"})
+	local := gitOutput(t, repo.dir, "rev-parse", "HEAD")
+	remoteHead := gitOutput(t, remote, "rev-parse", "main")
+
+	_, err := repo.sync()
+	if err == nil || !strings.Contains(err.Error(), "conflict with the remote") || !strings.Contains(err.Error(), "docker.sh") {
+		t.Fatalf("sync() = %v, want a conflict on docker.sh", err)
+	}
+
+	// The rebase is aborted, leaving the local commit and the remote alone
+	if _, err := os.Stat(filepath.Join(repo.dir, ".git", "rebase-merge")); !os.IsNotExist(err) {
+		t.Error("rebase was left in progress")
+	}
+	if got := gitOutput(t, repo.dir, "rev-parse", "HEAD"); got != local {
+		t.Errorf("HEAD = %s, want the local commit %s", got, local)
+	}
+	if got := gitOutput(t, remote, "rev-parse", "main"); got != remoteHead {
+		t.Error("conflicting commit was pushed")
+	}
+	content, err := os.ReadFile(filepath.Join(repo.dir, "scripts", "docker.sh"))
+	if err != nil || string(content) != "#!/bin/sh\necho from admin\n" {
+		t.Errorf("docker.sh = %q, %v", content, err)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Redirect targets are checked in the background so that renamed branches
+// and deleted gists show up on the dashboard before users run into them.
+
+// brokenAfterFailures is how many checks in a row must fail before a
+// redirect counts as broken, so a single blip does not hide it
+const brokenAfterFailures = 2
+
+// UpstreamHealth is the outcome of the last check of a redirect's target
+type UpstreamHealth struct {
+	URL         string     `json:"url"`
+	StatusCode  int        `json:"status_code,omitempty"`
+	LatencyMS   int64      `json:"latency_ms"`
+	LastCheck   time.Time  `json:"last_check"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	Failures    int        `json:"failures"`
+	// Broken is set once the target has failed enough checks in a row
+	Broken bool   `json:"broken"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthChecker checks redirect targets and keeps the results in
+// DATA_PATH/health.json
+type HealthChecker struct {
+	mu       sync.Mutex
+	checkMu  sync.Mutex
+	path     string
+	statuses map[string]UpstreamHealth
+	client   *http.Client
+	trigger  chan struct{}
+}
+
+var healthChecker *HealthChecker
+
+// hideBrokenScripts leaves broken redirects out of the generated index page
+// when HIDE_BROKEN_SCRIPTS=true
+var hideBrokenScripts bool
+
+func newHealthChecker(path string) *HealthChecker {
+	h := &HealthChecker{
+		path:     path,
+		statuses: map[string]UpstreamHealth{},
+		client:   &http.Client{Timeout: 15 * time.Second},
+		trigger:  make(chan struct{}, 1),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read health file: %v", err)
+		}
+		return h
+	}
+	if err := json.Unmarshal(data, &h.statuses); err != nil {
+		log.Printf("Failed to parse health file: %v", err)
+	}
+	return h
+}
+
+func (h *HealthChecker) save() error {
+	data, err := json.MarshalIndent(h.statuses, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(h.path, data, 0644)
+}
+
+// Status returns the last check of a script's redirect target. Results for
+// an earlier URL of the script are not returned.
+func (h *HealthChecker) Status(script ScriptConfig) (UpstreamHealth, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status, ok := h.statuses[script.Name]
+	if !ok || status.URL != script.RedirectURL {
+		return UpstreamHealth{}, false
+	}
+	return status, true
+}
+
+// Broken reports whether a redirect script's target is known to be broken
+func (h *HealthChecker) Broken(script ScriptConfig) bool {
+	if script.Type != "redirect" {
+		return false
+	}
+	status, ok := h.Status(script)
+	return ok && status.Broken
+}
+
+// Trigger asks Run to check now without waiting for the interval
+func (h *HealthChecker) Trigger() {
+	select {
+	case h.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run checks all redirect targets at start, every interval and on Trigger
+func (h *HealthChecker) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.CheckAll()
+		select {
+		case <-ticker.C:
+		case <-h.trigger:
+		}
+	}
+}
+
+// CheckAll checks every redirect script's target and regenerates the index
+// page when a script became broken or recovered while HIDE_BROKEN_SCRIPTS
+// is set
+func (h *HealthChecker) CheckAll() {
+	h.checkMu.Lock()
+	defer h.checkMu.Unlock()
+
+	statuses := map[string]UpstreamHealth{}
+	changed := false
+
+	for _, script := range configStore.Scripts() {
+		if script.Type != "redirect" || script.RedirectURL == "" {
+			continue
+		}
+		previous, _ := h.Status(script)
+		status := h.check(script.RedirectURL, previous)
+		if status.Error != "" {
+			log.Printf("Redirect target of %s is failing: %s", script.Name, status.Error)
+		}
+		if status.Broken != previous.Broken {
+			changed = true
+			if status.Broken {
+				log.Printf("Redirect target of %s is broken after %d failed checks", script.Name, status.Failures)
+			} else {
+				log.Printf("Redirect target of %s recovered", script.Name)
+			}
+		}
+		statuses[script.Name] = status
+	}
+
+	h.mu.Lock()
+	h.statuses = statuses
+	if err := h.save(); err != nil {
+		log.Printf("Failed to save health file: %v", err)
+	}
+	h.mu.Unlock()
+
+	if changed && hideBrokenScripts {
+		updateIndexPageWithCurrentScripts()
+	}
+}
+
+// check requests url with HEAD, falling back to GET for servers that do not
+// allow HEAD. Redirects are followed; any final 2xx counts as healthy.
+func (h *HealthChecker) check(url string, previous UpstreamHealth) UpstreamHealth {
+	status := UpstreamHealth{
+		URL:         url,
+		LastCheck:   time.Now().UTC(),
+		LastSuccess: previous.LastSuccess,
+		Failures:    previous.Failures,
+	}
+
+	start := time.Now()
+	code, err := h.request(http.MethodHead, url)
+	if err == nil && (code == http.StatusMethodNotAllowed || code == http.StatusNotImplemented || code == http.StatusForbidden) {
+		code, err = h.request(http.MethodGet, url)
+	}
+	status.LatencyMS = time.Since(start).Milliseconds()
+	status.StatusCode = code
+
+	switch {
+	case err != nil:
+		status.Error = err.Error()
+	case code < 200 || code > 299:
+		status.Error = fmt.Sprintf("upstream returned %d %s", code, http.StatusText(code))
+	}
+
+	if status.Error != "" {
+		status.Failures++
+	} else {
+		status.Failures = 0
+		lastSuccess := status.LastCheck
+		status.LastSuccess = &lastSuccess
+	}
+	status.Broken = status.Failures >= brokenAfterFailures
+	return status
+}
+
+func (h *HealthChecker) request(method, url string) (int, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "script-admin-health")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Only the status matters; read a little so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	return resp.StatusCode, nil
+}
+
+// healthCheckInterval reads HEALTH_CHECK_INTERVAL, defaulting to ten minutes
+func healthCheckInterval() time.Duration {
+	if interval, err := time.ParseDuration(os.Getenv("HEALTH_CHECK_INTERVAL")); err == nil && interval > 0 {
+		return interval
+	}
+	return 10 * time.Minute
+}
+
+// indexScripts returns the scripts to list on the index page
+func indexScripts(scripts []ScriptConfig) []ScriptConfig {
+	if !hideBrokenScripts {
+		return scripts
+	}
+	visible := make([]ScriptConfig, 0, len(scripts))
+	for _, script := range scripts {
+		if healthChecker.Broken(script) {
+			continue
+		}
+		visible = append(visible, script)
+	}
+	return visible
+}
+
+// checkHealthAPI checks all redirect targets now and returns their status
+func checkHealthAPI(c *fiber.Ctx) error {
+	healthChecker.CheckAll()
+
+	statuses := map[string]UpstreamHealth{}
+	for _, script := range configStore.Scripts() {
+		if status, ok := healthChecker.Status(script); ok && script.Type == "redirect" {
+			statuses[script.Name] = status
+		}
+	}
+	return c.JSON(statuses)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeTargets serves redirect targets: /ok answers HEAD, /no-head and
+// /forbidden-head only answer GET, and /down fails until it is brought up
+type fakeTargets struct {
+	mu      sync.Mutex
+	up      bool
+	methods map[string][]string
+}
+
+func (f *fakeTargets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.methods[r.URL.Path] = append(f.methods[r.URL.Path], r.Method)
+	switch {
+	case r.URL.Path == "/no-head" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	case r.URL.Path == "/forbidden-head" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusForbidden)
+	case r.URL.Path == "/down" && !f.up:
+		w.WriteHeader(http.StatusNotFound)
+	case r.URL.Path == "/moved":
+		http.Redirect(w, r, "/ok", http.StatusFound)
+	}
+}
+
+func (f *fakeTargets) setUp(up bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.up = up
+}
+
+func TestHealthCheckMethods(t *testing.T) {
+	targets := &fakeTargets{methods: map[string][]string{}}
+	server := httptest.NewServer(targets)
+	defer server.Close()
+	checker := newHealthChecker(filepath.Join(t.TempDir(), "health.json"))
+
+	tests := []struct {
+		path    string
+		code    int
+		methods string
+	}{
+		{"/ok", 200, "HEAD"},
+		{"/no-head", 200, "HEAD,GET"},
+		{"/forbidden-head", 200, "HEAD,GET"},
+		{"/moved", 200, "HEAD"},
+		{"/down", 404, "HEAD"},
+	}
+	for _, tt := range tests {
+		status := checker.check(server.URL+tt.path, UpstreamHealth{})
+		if status.StatusCode != tt.code || (status.Error == "") != (tt.code == 200) {
+			t.Errorf("check(%s) = %d %q, want %d", tt.path, status.StatusCode, status.Error, tt.code)
+		}
+		if got := strings.Join(targets.methods[tt.path], ","); got != tt.methods {
+			t.Errorf("check(%s) sent %s, want %s", tt.path, got, tt.methods)
+		}
+	}
+
+	status := checker.check("http://127.0.0.1:1/unreachable", UpstreamHealth{})
+	if status.Error == "" || status.Failures != 1 {
+		t.Errorf("check of an unreachable target = %+v", status)
+	}
+}
+
+func TestHealthCheckBrokenAfterFailures(t *testing.T) {
+	targets := &fakeTargets{methods: map[string][]string{}}
+	server := httptest.NewServer(targets)
+	defer server.Close()
+	checker := newHealthChecker(filepath.Join(t.TempDir(), "health.json"))
+	url := server.URL + "/down"
+
+	targets.setUp(true)
+	status := checker.check(url, UpstreamHealth{})
+	if status.Broken || status.Failures != 0 || status.LastSuccess == nil {
+		t.Fatalf("healthy check = %+v", status)
+	}
+	lastSuccess := *status.LastSuccess
+
+	targets.setUp(false)
+	for i := 1; i <= brokenAfterFailures+1; i++ {
+		status = checker.check(url, status)
+		if status.Failures != i || status.Broken != (i >= brokenAfterFailures) {
+			t.Errorf("after %d failures: failures = %d, broken = %t", i, status.Failures, status.Broken)
+		}
+		if status.LastSuccess == nil || !status.LastSuccess.Equal(lastSuccess) {
+			t.Errorf("after %d failures: last success = %v, want %v", i, status.LastSuccess, lastSuccess)
+		}
+	}
+
+	targets.setUp(true)
+	status = checker.check(url, status)
+	if status.Broken || status.Failures != 0 || !status.LastSuccess.After(lastSuccess) {
+		t.Errorf("recovered check = %+v", status)
+	}
+}
+
+func TestCheckAllHidesBrokenScripts(t *testing.T) {
+	targets := &fakeTargets{methods: map[string][]string{}}
+	server := httptest.NewServer(targets)
+	defer server.Close()
+	s := newTestServer(t, fmt.Sprintf(`scripts:
+  - name: healthy-tool
+    type: redirect
+    redirect_url: %s/ok
+  - name: flaky-tool
+    type: redirect
+    redirect_url: %s/down
+  - name: local-tool
+    type: local
+`, server.URL, server.URL))
+	s.writeScript("local-tool", "#!/bin/sh\nset -e\n")
+	swapGlobal(t, &hideBrokenScripts, true)
+	indexPath := filepath.Join(scriptsPath, "index.html")
+
+	listed := func() string {
+		var names []string
+		for _, script := range indexScripts(configStore.Scripts()) {
+			names = append(names, script.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	// One failure is not enough to hide a script
+	healthChecker.CheckAll()
+	if got := listed(); got != "healthy-tool,flaky-tool,local-tool" {
+		t.Errorf("listed after one failure = %s", got)
+	}
+	if _, err := os.Stat(indexPath); !os.IsNotExist(err) {
+		t.Error("index page regenerated without a change")
+	}
+
+	status, body := s.request("POST", "/admin/health/check", s.token("editor", scopeFull), nil)
+	var statuses map[string]UpstreamHealth
+	decodeJSON(t, body, &statuses)
+	if status != 200 || len(statuses) != 2 || !statuses["flaky-tool"].Broken || statuses["healthy-tool"].Broken {
+		t.Fatalf("health check = %d %s", status, body)
+	}
+	if got := listed(); got != "healthy-tool,local-tool" {
+		t.Errorf("listed once broken = %s", got)
+	}
+	index, err := os.ReadFile(indexPath)
+	if err != nil || strings.Contains(string(index), "flaky-tool") || !strings.Contains(string(index), "healthy-tool") {
+		t.Errorf("index page was not regenerated without the broken script: %v", err)
+	}
+
+	// Broken scripts are still listed unless HIDE_BROKEN_SCRIPTS is set
+	hideBrokenScripts = false
+	if got := listed(); got != "healthy-tool,flaky-tool,local-tool" {
+		t.Errorf("listed without HIDE_BROKEN_SCRIPTS = %s", got)
+	}
+	hideBrokenScripts = true
+
+	targets.setUp(true)
+	healthChecker.CheckAll()
+	if got := listed(); got != "healthy-tool,flaky-tool,local-tool" {
+		t.Errorf("listed after recovery = %s", got)
+	}
+	if index, err := os.ReadFile(indexPath); err != nil || !strings.Contains(string(index), "flaky-tool") {
+		t.Errorf("index page was not regenerated after recovery: %v", err)
+	}
+}
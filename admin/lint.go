@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// LintIssue is a problem found in script content. Issues with severity
+// "error" block the save; warnings are reported but do not.
+type LintIssue struct {
+	Line     uint   `json:"line"`
+	Column   uint   `json:"column"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// shellVariant picks the parser dialect from the shebang. Scripts for other
+// interpreters (python, perl, ...) are not shell and are not analysed.
+func shellVariant(content []byte) (syntax.LangVariant, bool) {
+	firstLine, _, _ := bytes.Cut(content, []byte("\n"))
+	if !bytes.HasPrefix(firstLine, []byte("#!")) {
+		return syntax.LangBash, true
+	}
+
+	fields := strings.Fields(string(firstLine[2:]))
+	if len(fields) == 0 {
+		return syntax.LangBash, true
+	}
+	interpreter := path.Base(fields[0])
+	if interpreter == "env" && len(fields) > 1 {
+		interpreter = fields[1]
+	}
+
+	switch interpreter {
+	case "bash":
+		return syntax.LangBash, true
+	case "sh", "dash", "ash":
+		return syntax.LangPOSIX, true
+	case "mksh", "ksh":
+		return syntax.LangMirBSDKorn, true
+	}
+	return 0, false
+}
+
+// lintScript parses shell content and runs the lint rules. A syntax error
+// is returned as a single issue with severity "error".
+func lintScript(name string, content []byte) []LintIssue {
+	issues := []LintIssue{}
+
+	variant, isShell := shellVariant(content)
+	if !isShell {
+		return issues
+	}
+
+	file, err := syntax.NewParser(syntax.Variant(variant)).Parse(bytes.NewReader(content), name)
+	if err != nil {
+		issue := LintIssue{Line: 1, Column: 1, Rule: "syntax", Severity: "error", Message: err.Error()}
+		var parseErr syntax.ParseError
+		var langErr syntax.LangError
+		if errors.As(err, &parseErr) {
+			issue.Line, issue.Column = parseErr.Pos.Line(), parseErr.Pos.Col()
+			issue.Message = parseErr.Text
+		} else if errors.As(err, &langErr) {
+			// e.g. bash arrays in a #!/bin/sh script
+			issue.Line, issue.Column = langErr.Pos.Line(), langErr.Pos.Col()
+			issue.Message = strings.TrimPrefix(err.Error(), fmt.Sprintf("%s:%s: ", name, langErr.Pos))
+		}
+		return append(issues, issue)
+	}
+
+	if !bytes.HasPrefix(content, []byte("#!")) {
+		issues = append(issues, LintIssue{Line: 1, Column: 1, Rule: "shebang", Severity: "warning",
+			Message: "missing shebang, e.g. #!/bin/bash"})
+	}
+
+	errexit := shebangHasErrexit(content)
+	syntax.Walk(file, func(node syntax.Node) bool {
+		call, ok := node.(*syntax.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+
+		args := call.Args[1:]
+		switch call.Args[0].Lit() {
+		case "set":
+			if setsErrexit(args) {
+				errexit = true
+			}
+		case "rm":
+			recursive := hasFlag(args, 'r', "recursive") || hasFlag(args, 'R', "")
+			if recursive && hasFlag(args, 'f', "force") {
+				for _, arg := range args {
+					if hasUnquotedExpansion(arg) {
+						issues = append(issues, LintIssue{Line: arg.Pos().Line(), Column: arg.Pos().Col(),
+							Rule: "rm-unquoted", Severity: "warning",
+							Message: "unquoted variable in rm -rf; an empty or spaced value can delete the wrong files"})
+					}
+				}
+			}
+		case "curl":
+			if !hasFlag(args, 'f', "fail") && !hasFlag(args, 0, "fail-with-body") {
+				issues = append(issues, LintIssue{Line: call.Pos().Line(), Column: call.Pos().Col(),
+					Rule: "curl-fail", Severity: "warning",
+					Message: "curl without -f saves or pipes HTTP error pages as if they succeeded"})
+			}
+		}
+		return true
+	})
+
+	if !errexit {
+		issues = append(issues, LintIssue{Line: 1, Column: 1, Rule: "set-e", Severity: "warning",
+			Message: "missing set -e; the script keeps running after a command fails"})
+	}
+
+	return issues
+}
+
+func lintHasErrors(issues []LintIssue) bool {
+	for _, issue := range issues {
+		if issue.Severity == "error" {
+			return true
+		}
+	}
+	return false
+}
+
+func shebangHasErrexit(content []byte) bool {
+	firstLine, _, _ := bytes.Cut(content, []byte("\n"))
+	if !bytes.HasPrefix(firstLine, []byte("#!")) {
+		return false
+	}
+	for _, field := range strings.Fields(string(firstLine))[1:] {
+		if strings.HasPrefix(field, "-") && !strings.HasPrefix(field, "--") && strings.Contains(field, "e") {
+			return true
+		}
+	}
+	return false
+}
+
+// setsErrexit reports whether `set` arguments enable errexit (-e, -eu,
+// -o errexit)
+func setsErrexit(args []*syntax.Word) bool {
+	for i, arg := range args {
+		lit := arg.Lit()
+		if strings.HasPrefix(lit, "-") && !strings.HasPrefix(lit, "--") && strings.Contains(lit, "e") {
+			return true
+		}
+		if lit == "-o" && i+1 < len(args) && args[i+1].Lit() == "errexit" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasFlag reports whether args contain a short flag (alone or clustered, as
+// in -fsSL) or its long form
+func hasFlag(args []*syntax.Word, short rune, long string) bool {
+	for _, arg := range args {
+		lit := arg.Lit()
+		if long != "" && lit == "--"+long {
+			return true
+		}
+		if short != 0 && strings.HasPrefix(lit, "-") && !strings.HasPrefix(lit, "--") &&
+			strings.ContainsRune(lit[1:], short) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasUnquotedExpansion reports whether a word contains $var or ${var}
+// outside double quotes
+func hasUnquotedExpansion(word *syntax.Word) bool {
+	for _, part := range word.Parts {
+		if _, ok := part.(*syntax.ParamExp); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// lintScriptAPI checks proposed content without saving it
+func lintScriptAPI(c *fiber.Ctx) error {
+	script, ok := findLocalScript(c.Params("name"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Script not found or not local"})
+	}
+
+	var body struct {
+		Content string `json:"content"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	return c.JSON(fiber.Map{"issues": lintScriptContent(script, []byte(body.Content))})
+}
+
+// lintErrorMessage summarises blocking issues for an error response
+func lintErrorMessage(issues []LintIssue) string {
+	for _, issue := range issues {
+		if issue.Severity == "error" {
+			return fmt.Sprintf("Script does not parse: line %d, column %d: %s", issue.Line, issue.Column, issue.Message)
+		}
+	}
+	return ""
+}
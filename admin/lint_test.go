@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLintScript(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string // "rule line:column" per issue
+	}{
+		{"clean", "#!/bin/bash\nset -e\ncurl -fsSL https://example.com/x | sh\nrm -rf \"$DIR\"\n", nil},
+		{"missing shebang", "set -e\necho hi\n", []string{"shebang 1:1"}},
+		{"missing set -e", "#!/bin/sh\necho hi\n", []string{"set-e 1:1"}},
+		{"errexit in shebang", "#!/bin/bash -eu\necho hi\n", nil},
+		{"set -o errexit", "#!/bin/bash\nset -o errexit\n", nil},
+		{"rm with unquoted variable", "#!/bin/bash\nset -e\nif true; then\n  rm -rf $DIR/build\nfi\n", []string{"rm-unquoted 4:10"}},
+		{"rm --recursive --force", "#!/bin/bash\nset -e\nrm --recursive --force ${DIR}\n", []string{"rm-unquoted 3:24"}},
+		{"rm without force", "#!/bin/bash\nset -e\nrm -r $DIR\n", nil},
+		{"curl without -f", "#!/bin/bash\nset -e\n  curl -sSL https://example.com/x -o x\n", []string{"curl-fail 3:3"}},
+		{"curl --fail-with-body", "#!/bin/bash\nset -e\ncurl --fail-with-body https://example.com/x\n", nil},
+		{"syntax error", "#!/bin/bash\nset -e\nif true; then\n  echo hi\n", []string{"syntax 3:1"}},
+		{"bash array in sh", "#!/bin/sh\nset -e\nhosts=(a b)\n", []string{"syntax 3:7"}},
+		{"not shell", "#!/usr/bin/env python3\nprint('hi'\n", nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, issue := range lintScript("test.sh", []byte(tt.content)) {
+			got = append(got, fmt.Sprintf("%s %d:%d", issue.Rule, issue.Line, issue.Column))
+		}
+		if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+			t.Errorf("%s: issues = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSaveRefusesSyntaxErrors(t *testing.T) {
+	s := newTestServer(t, "scripts:\n  - name: hello\n    type: local\n")
+	live := "#!/bin/bash\nset -e\necho hello\n"
+	s.writeScript("hello", live)
+	editor := s.token("editor", scopeFull)
+
+	status, body := s.request("PUT", "/admin/scripts/hello/content", editor, map[string]string{"content": "#!/bin/bash\nset -e\nif true; then\n"})
+	var refused struct {
+		Error  string      `json:"error"`
+		Issues []LintIssue `json:"issues"`
+	}
+	decodeJSON(t, body, &refused)
+	if status != 422 || len(refused.Issues) != 1 || refused.Issues[0].Rule != "syntax" || !strings.Contains(refused.Error, "line 3") {
+		t.Errorf("save with a syntax error = %d %s, want 422 with the syntax issue", status, body)
+	}
+	if content, _ := os.ReadFile(filepath.Join(scriptsPath, "hello.sh")); string(content) != live {
+		t.Errorf("refused save changed the script to %q", content)
+	}
+
+	// Warnings are reported but do not block the save
+	status, body = s.request("PUT", "/admin/scripts/hello/content", editor, map[string]string{"content": "#!/bin/bash\necho hi\n"})
+	if status != 200 || !strings.Contains(string(body), `"set-e"`) {
+		t.Errorf("save with a warning = %d %s, want 200 with the set-e warning", status, body)
+	}
+}
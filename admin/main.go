@@ -1,13 +1,13 @@
 package main
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -16,15 +16,17 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/session"
 	"github.com/gofiber/template/html/v2"
 	"golang.org/x/crypto/bcrypt"
-	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
+	// Admin is the single account of older configs; see Config.users
 	Admin struct {
 		Username string `yaml:"username"`
 		Password string `yaml:"password_hash"`
-	} `yaml:"admin"`
-	Scripts []ScriptConfig `yaml:"scripts"`
+	} `yaml:"admin,omitempty"`
+	Users    []User         `yaml:"users,omitempty"`
+	Scripts  []ScriptConfig `yaml:"scripts"`
+	Webhooks []Webhook      `yaml:"webhooks,omitempty"`
 }
 
 type ScriptConfig struct {
@@ -32,9 +34,26 @@ type ScriptConfig struct {
 	Path        string `yaml:"path" json:"path"`
 	Description string `yaml:"description" json:"description"`
 	Icon        string `yaml:"icon" json:"icon"`
-	Type        string `yaml:"type" json:"type"` // "local" or "redirect"
-	RedirectURL string `yaml:"redirect_url,omitempty" json:"redirect_url,omitempty"`
+	Type        string `yaml:"type" json:"type"` // "local", "redirect" or "mirror"
+	RedirectURL string `yaml:"redirect_url,omitempty" json:"redirect_url,omitempty"` // upstream URL of redirect and mirror scripts
 	ScriptPath  string `yaml:"script_path,omitempty" json:"script_path,omitempty"`
+	Status      string `yaml:"status,omitempty" json:"status,omitempty"` // "published" or "pending_review"
+	// Category groups the script on the index page; tags help to find it
+	Category string   `yaml:"category,omitempty" json:"category,omitempty"`
+	Tags     []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+	// Template renders a local script with Params from the query string
+	Template bool          `yaml:"template,omitempty" json:"template,omitempty"`
+	Params   []ScriptParam `yaml:"params,omitempty" json:"params,omitempty"`
+	// Git makes a local script follow a file in a git repository
+	Git *GitSource `yaml:"git,omitempty" json:"git,omitempty"`
+	// Sync is the last git sync of the script, filled in for API responses
+	Sync *SourceStatus `yaml:"-" json:"sync,omitempty"`
+	// PinUpstream holds upstream changes of a mirror until they are accepted
+	PinUpstream bool `yaml:"pin_upstream,omitempty" json:"pin_upstream,omitempty"`
+	// Mirror is the cache status of a mirror script, filled in for API responses
+	Mirror *MirrorStatus `yaml:"-" json:"mirror,omitempty"`
+	// Health is the last check of a redirect's target, filled in for API responses
+	Health *UpstreamHealth `yaml:"-" json:"health,omitempty"`
 }
 
 type IndexPageData struct {
@@ -42,18 +61,43 @@ type IndexPageData struct {
 }
 
 var (
-	config      Config
-	scriptsPath string
-	store       *session.Store
+	configStore  *ConfigStore
+	scriptsPath  string
+	dataPath     string
+	caddyEnabled bool
+	store        *session.Store
 )
 
 func main() {
 	// Initialize
-	loadConfig()
 	scriptsPath = os.Getenv("SCRIPTS_PATH")
 	if scriptsPath == "" {
 		scriptsPath = "/app/scripts"
 	}
+	dataPath = os.Getenv("DATA_PATH")
+	if dataPath == "" {
+		dataPath = "/app/data"
+	}
+	if err := initGit(configFilePath()); err != nil {
+		log.Fatal("Failed to set up git repository: ", err)
+	}
+	loadConfig()
+	// Set CADDY_ENABLED=false to run standalone, serving scripts without Caddy
+	caddyEnabled = os.Getenv("CADDY_ENABLED") != "false"
+	// Set REVIEW_ENABLED=false to publish content saves without review
+	reviewEnabled = os.Getenv("REVIEW_ENABLED") != "false"
+	// Set HIDE_BROKEN_SCRIPTS=true to leave broken redirects off the index page
+	hideBrokenScripts = os.Getenv("HIDE_BROKEN_SCRIPTS") == "true"
+	initCaddy()
+	tokenStore = newTokenStore(filepath.Join(dataPath, "tokens.json"))
+	statsStore = newStatsStore(filepath.Join(dataPath, "stats.json"))
+	sourceSyncer = newSourceSyncer(filepath.Join(dataPath, "sources.json"))
+	mirrorCache = newMirrorCache(filepath.Join(dataPath, "mirrors"))
+	healthChecker = newHealthChecker(filepath.Join(dataPath, "health.json"))
+	webhooks = newWebhookDispatcher(filepath.Join(dataPath, "webhooks"))
+	if err := initSigning(); err != nil {
+		log.Printf("Script signing disabled: %v", err)
+	}
 
 	// Initialize session store
 	store = session.New()
@@ -62,32 +106,33 @@ func main() {
 	engine := html.New("./templates", ".html")
 	engine.Reload(true)
 
+	// Behind a reverse proxy, take client addresses from PROXY_HEADER (e.g.
+	// X-Forwarded-For), but only on requests from TRUSTED_PROXIES if set
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+
 	app := fiber.New(fiber.Config{
-		Views: engine,
+		Views:                   engine,
+		ProxyHeader:             os.Getenv("PROXY_HEADER"),
+		EnableTrustedProxyCheck: len(trustedProxies) > 0,
+		TrustedProxies:          trustedProxies,
+		EnableIPValidation:      true,
 	})
 
 	// Middleware
 	app.Use(logger.New())
+	app.Use(metricsMiddleware)
 	app.Use(cors.New())
 
 	// Static files
 	app.Static("/static", "./static")
 
 	// Routes
-	app.Get("/", indexHandler)
-	app.Post("/login", loginHandler)
-	app.Get("/admin", authMiddleware, adminHandler)
-	app.Get("/admin/scripts", authMiddleware, getScriptsAPI)
-	app.Post("/admin/scripts", authMiddleware, createScriptAPI)
-	app.Put("/admin/scripts/:name", authMiddleware, updateScriptAPI)
-	app.Delete("/admin/scripts/:name", authMiddleware, deleteScriptAPI)
-	app.Get("/admin/scripts/:name/content", authMiddleware, getScriptContentAPI)
-	app.Put("/admin/scripts/:name/content", authMiddleware, updateScriptContentAPI)
-	app.Post("/admin/index-page", authMiddleware, updateIndexPageAPI)
-	app.Get("/admin/index-page", authMiddleware, getIndexPageAPI)
-	app.Post("/logout", logoutHandler)
-	app.Get("/admin/browse-files", authMiddleware, browseFilesAPI)
-	app.Get("/admin/browse", authMiddleware, browseFilesAPI)
+	setupRoutes(app)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -96,39 +141,101 @@ func main() {
 
 	// Generate initial index page with current scripts
 	updateIndexPageWithCurrentScripts()
+	signUnsignedScripts()
 
-	log.Printf("Admin dashboard starting on port %s", port)
-	log.Fatal(app.Listen(":" + port))
-}
-
-func loadConfig() {
-	configPath := os.Getenv("CONFIG_PATH")
-	if configPath == "" {
-		configPath = "./config.yaml"
+	// Caddy loses API-managed routes when it restarts from the Caddyfile
+	if err := syncCaddyRedirects(configStore.Scripts()); err != nil {
+		log.Printf("Failed to sync redirect routes with Caddy: %v", err)
 	}
 
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		log.Fatal("Failed to read config file:", err)
+	go configStore.Watch(2 * time.Second)
+	go statsStore.Run(10 * time.Second)
+	go sourceSyncer.Run(gitSourcesInterval())
+	go mirrorCache.Run(mirrorInterval())
+	go healthChecker.Run(healthCheckInterval())
+	if gitRepo != nil {
+		go gitRepo.Run(gitSyncInterval())
 	}
 
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		log.Fatal("Failed to parse config file:", err)
-	}
+	log.Printf("Admin dashboard starting on port %s", port)
+	log.Fatal(app.Listen(":" + port))
 }
 
-func saveConfig() error {
-	configPath := os.Getenv("CONFIG_PATH")
-	if configPath == "" {
-		configPath = "./config.yaml"
-	}
+// setupRoutes registers the dashboard, API and public routes
+func setupRoutes(app *fiber.App) {
+	app.Get("/", indexHandler)
+	app.Post("/login", loginHandler)
+	app.Get("/admin", authMiddleware, adminHandler)
+	app.Get("/admin/scripts", authMiddleware, getScriptsAPI)
+	app.Post("/admin/scripts", authMiddleware, requireRole(roleEditor), createScriptAPI)
+	app.Put("/admin/scripts/:name", authMiddleware, requireRole(roleEditor), updateScriptAPI)
+	app.Delete("/admin/scripts/:name", authMiddleware, requireRole(roleAdmin), deleteScriptAPI)
+	app.Get("/admin/scripts/:name/content", authMiddleware, getScriptContentAPI)
+	app.Put("/admin/scripts/:name/content", authMiddleware, requireRole(roleEditor), updateScriptContentAPI)
+	app.Get("/admin/scripts/:name/diff", authMiddleware, diffScriptAPI)
+	app.Post("/admin/scripts/:name/diff", authMiddleware, requireRole(roleEditor), diffScriptAPI)
+	app.Post("/admin/scripts/:name/lint", authMiddleware, lintScriptAPI)
+	app.Get("/admin/scripts/:name/draft", authMiddleware, getDraftAPI)
+	app.Post("/admin/scripts/:name/draft/approve", authMiddleware, requireRole(roleEditor), approveDraftAPI)
+	app.Delete("/admin/scripts/:name/draft", authMiddleware, requireRole(roleEditor), discardDraftAPI)
+	app.Get("/admin/reviews", authMiddleware, listReviewsAPI)
+	app.Post("/admin/sources/sync", authMiddleware, requireRole(roleEditor), syncSourcesAPI)
+	app.Post("/admin/health/check", authMiddleware, requireRole(roleEditor), checkHealthAPI)
+	app.Get("/admin/scripts/:name/mirror", authMiddleware, getMirrorAPI)
+	app.Post("/admin/scripts/:name/mirror/refresh", authMiddleware, requireRole(roleEditor), refreshMirrorAPI)
+	app.Post("/admin/scripts/:name/mirror/accept", authMiddleware, requireRole(roleEditor), acceptMirrorAPI)
+	app.Get("/admin/scripts/:name/stats", authMiddleware, scriptStatsAPI)
+	app.Get("/admin/stats", authMiddleware, allStatsAPI)
+	app.Get("/admin/scripts/:name/revisions", authMiddleware, listRevisionsAPI)
+	app.Get("/admin/scripts/:name/revisions/:id", authMiddleware, getRevisionAPI)
+	app.Post("/admin/scripts/:name/revisions/:id/rollback", authMiddleware, requireRole(roleEditor), rollbackRevisionAPI)
+	app.Post("/admin/index-page", authMiddleware, requireRole(roleEditor), updateIndexPageAPI)
+	app.Get("/admin/index-page", authMiddleware, getIndexPageAPI)
+	app.Post("/logout", logoutHandler)
+	app.Get("/admin/browse-files", authMiddleware, requireRole(roleEditor), browseFilesAPI)
+	app.Get("/admin/browse", authMiddleware, requireRole(roleEditor), browseFilesAPI)
+	app.Get("/admin/users", authMiddleware, requireRole(roleAdmin), listUsersAPI)
+	app.Post("/admin/users", authMiddleware, requireRole(roleAdmin), createUserAPI)
+	app.Put("/admin/users/:username", authMiddleware, requireRole(roleAdmin), updateUserAPI)
+	app.Post("/admin/users/:username/password", authMiddleware, resetPasswordAPI)
+	app.Get("/admin/tokens", authMiddleware, listTokensAPI)
+	app.Post("/admin/tokens", authMiddleware, createTokenAPI)
+	app.Delete("/admin/tokens/:id", authMiddleware, revokeTokenAPI)
+	app.Get("/admin/audit", authMiddleware, requireRole(roleAdmin), getAuditAPI)
+	app.Get("/admin/webhooks", authMiddleware, requireRole(roleAdmin), listWebhooksAPI)
+	app.Get("/admin/webhooks/:name/deliveries", authMiddleware, requireRole(roleAdmin), webhookDeliveriesAPI)
+	app.Post("/admin/webhooks/:name/test", authMiddleware, requireRole(roleAdmin), testWebhookAPI)
+	app.Get("/admin/git", authMiddleware, requireRole(roleAdmin), gitStatusAPI)
+	app.Post("/admin/git/sync", authMiddleware, requireRole(roleAdmin), gitSyncAPI)
+
+	// Public script delivery
+	app.Get("/health", healthHandler)
+	app.Post("/hooks/git", gitWebhookHandler)
+	app.Get("/metrics", metricsEndpoint)
+	app.Get("/index.html", publicIndexHandler)
+	app.Get(publicKeyPath, publicKeyHandler)
+	app.Get("/:name.sig", scriptSignatureHandler)
+	app.Get("/:name.sha256", scriptChecksumHandler)
+	app.Get("/SHA256SUMS", checksumManifestHandler)
+	app.Get("/catalog.json", catalogJSONHandler)
+	app.Get("/catalog.yaml", catalogYAMLHandler)
+	app.Get("/feed.atom", feedHandler)
+	app.Get("/feed/:name.atom", scriptFeedHandler)
+	app.Get("/:name", publicScriptHandler)
+}
 
-	data, err := yaml.Marshal(&config)
-	if err != nil {
-		return err
+func configFilePath() string {
+	if configPath := os.Getenv("CONFIG_PATH"); configPath != "" {
+		return configPath
 	}
+	return "./config.yaml"
+}
 
-	return os.WriteFile(configPath, data, 0644)
+func loadConfig() {
+	configStore = newConfigStore(configFilePath())
+	if err := configStore.Load(); err != nil {
+		log.Fatal("Failed to load config file:", err)
+	}
 }
 
 func indexHandler(c *fiber.Ctx) error {
@@ -141,16 +248,39 @@ func loginHandler(c *fiber.Ctx) error {
 	username := c.FormValue("username")
 	password := c.FormValue("password")
 
-	if username == config.Admin.Username {
-		if err := bcrypt.CompareHashAndPassword([]byte(config.Admin.Password), []byte(password)); err == nil {
+	ip := c.IP()
+	if loginAttempts.blocked(ip, time.Now()) {
+		loginFailuresTotal.WithLabelValues("password").Inc()
+		return c.Status(429).Render("login", fiber.Map{
+			"Title": "Script Server Admin",
+			"Error": "Too many failed logins. Try again later.",
+		})
+	}
+
+	if user, ok := configStore.Get().findUser(username); ok && !user.Disabled {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err == nil {
 			sess, _ := store.Get(c)
 			sess.Set("authenticated", true)
 			sess.Set("username", username)
 			sess.Save()
+			loginAttempts.reset(ip)
+			recordAudit(c, AuditEntry{Actor: username, Action: "login"})
 			return c.Redirect("/admin")
 		}
 	}
 
+	loginFailuresTotal.WithLabelValues("password").Inc()
+	// Failures past the limit are refused above and not audited one by one
+	switch failures := loginAttempts.fail(ip, time.Now()); {
+	case failures < loginFailureLimit:
+		recordAudit(c, AuditEntry{Actor: loginActor(username), Action: "login.failed"})
+	case failures == loginFailureLimit:
+		recordAudit(c, AuditEntry{
+			Actor:   loginActor(username),
+			Action:  "login.failed",
+			Details: fmt.Sprintf("%d failed logins, further attempts refused for %s", failures, loginFailureWindow),
+		})
+	}
 	return c.Render("login", fiber.Map{
 		"Title": "Script Server Admin",
 		"Error": "Invalid credentials",
@@ -164,33 +294,107 @@ func logoutHandler(c *fiber.Ctx) error {
 }
 
 func authMiddleware(c *fiber.Ctx) error {
+	if secret := bearerToken(c); secret != "" {
+		return tokenAuth(c, secret)
+	}
+
 	sess, _ := store.Get(c)
 
 	if auth := sess.Get("authenticated"); auth != true {
-		return c.Redirect("/")
+		return unauthorized(c)
+	}
+
+	// Look the user up on every request so role changes and disabled
+	// accounts take effect immediately
+	username, _ := sess.Get("username").(string)
+	user, ok := configStore.Get().findUser(username)
+	if !ok || user.Disabled {
+		sess.Destroy()
+		return unauthorized(c)
+	}
+
+	c.Locals("user", user)
+	return c.Next()
+}
+
+func tokenAuth(c *fiber.Ctx, secret string) error {
+	token, ok := tokenStore.Authenticate(secret)
+	if !ok {
+		loginFailuresTotal.WithLabelValues("token").Inc()
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid or expired API token"})
+	}
+
+	user, ok := configStore.Get().findUser(token.Owner)
+	if !ok || user.Disabled {
+		return c.Status(401).JSON(fiber.Map{"error": "Token owner is disabled or no longer exists"})
+	}
+	if !scopeAllows(token.Scope, c) {
+		return c.Status(403).JSON(fiber.Map{"error": "Token scope '" + token.Scope + "' does not allow this request"})
 	}
 
+	c.Locals("user", user)
+	c.Locals("token", token)
 	return c.Next()
 }
 
+// unauthorized sends browsers back to the login page and gives API clients
+// a JSON 401
+func unauthorized(c *fiber.Ctx) error {
+	if c.Path() == "/admin" && c.Method() == fiber.MethodGet {
+		return c.Redirect("/")
+	}
+	return c.Status(401).JSON(fiber.Map{"error": "Authentication required"})
+}
+
 func adminHandler(c *fiber.Ctx) error {
+	user, _ := c.Locals("user").(User)
 	return c.Render("admin", fiber.Map{
-		"Title":   "Admin Dashboard",
-		"Scripts": config.Scripts,
+		"Title":    "Admin Dashboard",
+		"Scripts":  configStore.Scripts(),
+		"Username":      user.Username,
+		"Role":          user.Role,
+		"ReviewEnabled": reviewEnabled,
 	})
 }
 
 func getScriptsAPI(c *fiber.Ctx) error {
-    log.Printf("Returning %d scripts: %+v", len(config.Scripts), config.Scripts)
-    
-    // If no scripts, return empty array
-    if config.Scripts == nil {
-        return c.JSON([]ScriptConfig{})
-    }
-    
-    return c.JSON(config.Scripts)
+	scripts := configStore.Scripts()
+
+	// If no scripts, return empty array
+	if scripts == nil {
+		return c.JSON([]ScriptConfig{})
+	}
+
+	// Narrow the list down with ?q=, ?content=, ?category=, ?tag= and ?type=
+	if filter := parseScriptFilter(c); !filter.empty() {
+		matched := []ScriptConfig{}
+		for _, script := range scripts {
+			if filter.matches(script) {
+				matched = append(matched, script)
+			}
+		}
+		scripts = matched
+	}
+
+	for i := range scripts {
+		if status, ok := sourceSyncer.Status(scripts[i].Name); ok && scripts[i].Git != nil {
+			scripts[i].Sync = &status
+		}
+		if status, ok := mirrorCache.Status(scripts[i].Name); ok && scripts[i].Type == "mirror" {
+			scripts[i].Mirror = &status
+		}
+		if status, ok := healthChecker.Status(scripts[i]); ok && scripts[i].Type == "redirect" {
+			scripts[i].Health = &status
+		}
+	}
+
+	return c.JSON(scripts)
 }
 
+// createMu serializes script creation, so that cleaning up after a failed
+// create cannot remove files another request just made for the same name
+var createMu sync.Mutex
+
 // Replace the createScriptAPI function:
 func createScriptAPI(c *fiber.Ctx) error {
     var script ScriptConfig
@@ -199,8 +403,6 @@ func createScriptAPI(c *fiber.Ctx) error {
         return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
     }
 
-    log.Printf("Received script data: %+v", script)
-
     // Validate required fields FIRST
     if script.Name == "" {
         return c.Status(400).JSON(fiber.Map{"error": "Script name is required"})
@@ -209,11 +411,11 @@ func createScriptAPI(c *fiber.Ctx) error {
         return c.Status(400).JSON(fiber.Map{"error": "Description is required"})
     }
 
-    // Validate redirect URL for redirect type BEFORE any other processing
-    if script.Type == "redirect" {
+    // Validate redirect URL for redirect and mirror types BEFORE any other processing
+    if script.Type == "redirect" || script.Type == "mirror" {
         log.Printf("Validating redirect URL: %s", script.RedirectURL)
         if script.RedirectURL == "" {
-            return c.Status(400).JSON(fiber.Map{"error": "Redirect URL is required for redirect type scripts"})
+            return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Redirect URL is required for %s type scripts", script.Type)})
         }
         // Validate URL format
         if !strings.HasPrefix(script.RedirectURL, "http://") && !strings.HasPrefix(script.RedirectURL, "https://") {
@@ -237,13 +439,14 @@ func createScriptAPI(c *fiber.Ctx) error {
 
     log.Printf("Sanitized script name: %s", script.Name)
 
+    createMu.Lock()
+    defer createMu.Unlock()
+
     // Check if script already exists
-    for _, existing := range config.Scripts {
-        if existing.Name == script.Name {
-            return c.Status(409).JSON(fiber.Map{
-                "error": fmt.Sprintf("Script '%s' already exists. Please choose a different name.", script.Name),
-            })
-        }
+    if _, exists := findScript(script.Name); exists {
+        return c.Status(409).JSON(fiber.Map{
+            "error": fmt.Sprintf("Script '%s' already exists. Please choose a different name.", script.Name),
+        })
     }
 
     // Set defaults
@@ -256,8 +459,30 @@ func createScriptAPI(c *fiber.Ctx) error {
     if script.Path == "" {
         script.Path = script.Name
     }
+    script.Status = statusPublished
+    script.Category = strings.TrimSpace(script.Category)
+    script.Tags = normalizeTags(script.Tags)
+    if err := validateCatalogFields(script); err != nil {
+        return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+    }
+    if err := validateTemplateFields(script); err != nil {
+        return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+    }
+    if script.Git != nil {
+        if err := script.Git.validate(); err != nil {
+            return c.Status(400).JSON(fiber.Map{"error": "Invalid git source: " + err.Error()})
+        }
+    }
 
-    log.Printf("Final script config before processing: %+v", script)
+    var findings []ScanFinding
+
+    // cleanup undoes what was created for the script if it cannot be added
+    var undo []func()
+    cleanup := func() {
+        for i := len(undo) - 1; i >= 0; i-- {
+            undo[i]()
+        }
+    }
 
     // Handle script creation based on type
     if script.Type == "local" {
@@ -266,24 +491,43 @@ func createScriptAPI(c *fiber.Ctx) error {
         log.Printf("Creating local script with symlink path: %s", symlinkPath)
 
         if script.ScriptPath != "" {
+            resolved, ok := resolveScriptPath(script.ScriptPath)
+            if !ok {
+                return c.Status(400).JSON(fiber.Map{"error": "Selected script file must be inside the scripts directory"})
+            }
+            script.ScriptPath = resolved
+
+            // Linking publishes the file, so scan it like edited content
+            content, err := os.ReadFile(script.ScriptPath)
+            if err != nil {
+                return c.Status(400).JSON(fiber.Map{"error": "Selected script file cannot be read"})
+            }
+            findings = scanContent(content)
+            if len(findings) > 0 && !scanOverride(c) {
+                return scanBlockedResponse(c, findings)
+            }
+
             // User selected existing file - create symlink
             os.Remove(symlinkPath)
             if err := os.Symlink(script.ScriptPath, symlinkPath); err != nil {
                 log.Printf("Failed to create symlink: %v", err)
                 return c.Status(500).JSON(fiber.Map{"error": "Failed to link script file"})
             }
+            undo = append(undo, func() { os.Remove(symlinkPath) })
             log.Printf("Created symlink: %s -> %s", symlinkPath, script.ScriptPath)
         } else {
             // Create new script file
             scriptDir := filepath.Join(scriptsPath, script.Name+"_dir")
             os.MkdirAll(scriptDir, 0755)
+            undo = append(undo, func() { os.RemoveAll(scriptDir) })
 
             scriptFile := filepath.Join(scriptDir, script.Name+".sh")
-            defaultContent := fmt.Sprintf("#!/bin/bash\n\n# %s\n# Generated on %s\n\necho \"Hello from %s script!\"\necho \"Edit this script through the admin panel.\"\n",
+            defaultContent := fmt.Sprintf("#!/bin/bash\n\n# %s\n# Generated on %s\n\nset -e\n\necho \"Hello from %s script!\"\necho \"Edit this script through the admin panel.\"\n",
                 script.Description, time.Now().Format("2006-01-02 15:04:05"), originalName)
 
             if err := os.WriteFile(scriptFile, []byte(defaultContent), 0755); err != nil {
                 log.Printf("Failed to create script file: %v", err)
+                cleanup()
                 return c.Status(500).JSON(fiber.Map{"error": "Failed to create script file"})
             }
 
@@ -291,30 +535,87 @@ func createScriptAPI(c *fiber.Ctx) error {
             os.Remove(symlinkPath)
             if err := os.Symlink(scriptFile, symlinkPath); err != nil {
                 log.Printf("Failed to create symlink: %v", err)
+                cleanup()
                 return c.Status(500).JSON(fiber.Map{"error": "Failed to link script file"})
             }
+            undo = append(undo, func() { os.Remove(symlinkPath) })
 
             script.ScriptPath = scriptFile
             log.Printf("Created new script and symlink: %s -> %s", symlinkPath, scriptFile)
+
+            if _, err := saveRevision(script.Name, []byte(defaultContent), currentUser(c), "", ""); err != nil {
+                log.Printf("Failed to record initial revision: %v", err)
+            }
+        }
+
+        if content, err := os.ReadFile(localScriptFile(script)); err == nil {
+            if err := signScript(script.Name, content); err != nil {
+                log.Printf("Failed to sign %s: %v", script.Name, err)
+            }
+            undo = append(undo, func() { removeSignature(script.Name) })
         }
     } else if script.Type == "redirect" {
         log.Printf("Processing redirect script: %s -> %s", script.Name, script.RedirectURL)
-        // Update Caddyfile for redirect
-        if err := updateCaddyfileRedirect(script.Name, script.RedirectURL); err != nil {
-            log.Printf("Failed to update Caddyfile: %v", err)
+        // Add the redirect route to Caddy
+        if err := upsertCaddyRedirect(script.Name, script.RedirectURL); err != nil {
+            log.Printf("Failed to add Caddy redirect route: %v", err)
             return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to configure redirect: %v", err)})
         }
         log.Printf("Successfully added redirect for %s -> %s", script.Name, script.RedirectURL)
+        undo = append(undo, func() {
+            if err := removeCaddyRedirect(script.Name); err != nil {
+                log.Printf("Failed to remove Caddy redirect route: %v", err)
+            }
+        })
+        healthChecker.Trigger()
+    } else if script.Type == "mirror" {
+        log.Printf("Fetching mirror script: %s <- %s", script.Name, script.RedirectURL)
+        // Start from an empty cache so leftovers of a deleted script are not compared
+        mirrorCache.Remove(script.Name)
+        if _, err := mirrorCache.Refresh(script); err != nil {
+            mirrorCache.Remove(script.Name)
+            return c.Status(502).JSON(fiber.Map{"error": fmt.Sprintf("Failed to fetch upstream script: %v", err)})
+        }
+        undo = append(undo, func() { mirrorCache.Remove(script.Name) })
     }
 
     // Add to config AFTER successful creation
-    config.Scripts = append(config.Scripts, script)
-    if err := saveConfig(); err != nil {
+    err := configStore.Update(func(cfg *Config) error {
+        for _, existing := range cfg.Scripts {
+            if existing.Name == script.Name {
+                return errScriptExists
+            }
+        }
+        cfg.Scripts = append(cfg.Scripts, script)
+        return nil
+    })
+    if err != nil {
+        cleanup()
+    }
+    if err == errScriptExists {
+        return c.Status(409).JSON(fiber.Map{
+            "error": fmt.Sprintf("Script '%s' already exists. Please choose a different name.", script.Name),
+        })
+    }
+    if err == errConfigChanged {
+        return c.Status(409).JSON(fiber.Map{"error": "Config file changed on disk. Reload and try again."})
+    }
+    if errors.Is(err, errInvalidConfig) {
+        return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+    }
+    if err != nil {
         log.Printf("Failed to save config: %v", err)
         return c.Status(500).JSON(fiber.Map{"error": "Failed to save configuration"})
     }
 
-    log.Printf("Script added to config successfully: %+v", script)
+    log.Printf("Script %s (%s) added to config", script.Name, script.Type)
+
+    entry := AuditEntry{Action: "script.create", Script: script.Name, After: scriptPtr(script)}
+    if hash, ok := scriptChecksum(script); ok {
+        entry.AfterSHA256 = hash
+    }
+    recordAudit(c, entry)
+    auditScanOverride(c, script.Name, findings)
 
     // Auto-update index page
     if err := updateIndexPageWithCurrentScripts(); err != nil {
@@ -328,113 +629,198 @@ func createScriptAPI(c *fiber.Ctx) error {
 func updateScriptAPI(c *fiber.Ctx) error {
 	name := c.Params("name")
 	var updates ScriptConfig
+	// These may be cleared, so absence must be told apart from a zero value
+	var flags struct {
+		PinUpstream *bool          `json:"pin_upstream"`
+		Category    *string        `json:"category"`
+		Tags        *[]string      `json:"tags"`
+		Template    *bool          `json:"template"`
+		Params      *[]ScriptParam `json:"params"`
+	}
 
 	if err := c.BodyParser(&updates); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
+	if err := c.BodyParser(&flags); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	catalog := ScriptConfig{Name: name}
+	if flags.Category != nil {
+		*flags.Category = strings.TrimSpace(*flags.Category)
+		catalog.Category = *flags.Category
+	}
+	if flags.Tags != nil {
+		*flags.Tags = normalizeTags(*flags.Tags)
+		catalog.Tags = *flags.Tags
+	}
+	if err := validateCatalogFields(catalog); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
 
-	for i, script := range config.Scripts {
-		if script.Name == name {
-			// Save old type and redirect for comparison
-			oldType := script.Type
-			oldRedirect := script.RedirectURL
-
-			// Update fields
-			if updates.Description != "" {
-				config.Scripts[i].Description = updates.Description
-			}
-			if updates.Icon != "" {
-				config.Scripts[i].Icon = updates.Icon
-			}
-			if updates.Type != "" {
-				config.Scripts[i].Type = updates.Type
-			}
-			if updates.RedirectURL != "" {
-				config.Scripts[i].RedirectURL = updates.RedirectURL
-			}
-
-			if err := saveConfig(); err != nil {
-				return c.Status(500).JSON(fiber.Map{"error": "Failed to save config"})
-			}
+	var old, updated ScriptConfig
+	var invalid error
+	err := configStore.Update(func(cfg *Config) error {
+		for i, script := range cfg.Scripts {
+			if script.Name == name {
+				old = script
 
-			// If type or redirect changed, update Caddyfile
-			if oldType == "redirect" && (updates.Type != "redirect" || updates.RedirectURL != oldRedirect) {
-				// Remove old redirect
-				if err := removeCaddyfileRedirect(script.Name); err != nil {
-					log.Printf("Failed to remove old Caddyfile redirect: %v", err)
+				// Update fields
+				if updates.Description != "" {
+					cfg.Scripts[i].Description = updates.Description
 				}
-			}
-			if config.Scripts[i].Type == "redirect" && config.Scripts[i].RedirectURL != "" {
-				// Add or update new redirect
-				if err := updateCaddyfileRedirect(script.Name, config.Scripts[i].RedirectURL); err != nil {
-					log.Printf("Failed to update Caddyfile redirect: %v", err)
+				if updates.Icon != "" {
+					cfg.Scripts[i].Icon = updates.Icon
 				}
-			}
-			if (oldType == "redirect" || config.Scripts[i].Type == "redirect") && (updates.RedirectURL != "" || updates.Type != "") {
-				if err := reloadCaddy(); err != nil {
-					log.Printf("Failed to reload Caddy: %v", err)
+				if updates.Type != "" {
+					cfg.Scripts[i].Type = updates.Type
 				}
+				if updates.RedirectURL != "" {
+					cfg.Scripts[i].RedirectURL = updates.RedirectURL
+				}
+				if flags.PinUpstream != nil {
+					cfg.Scripts[i].PinUpstream = *flags.PinUpstream
+				}
+				if flags.Category != nil {
+					cfg.Scripts[i].Category = *flags.Category
+				}
+				if flags.Tags != nil {
+					cfg.Scripts[i].Tags = *flags.Tags
+				}
+				if flags.Template != nil {
+					cfg.Scripts[i].Template = *flags.Template
+				}
+				if flags.Params != nil {
+					cfg.Scripts[i].Params = *flags.Params
+				}
+				invalid = validateTemplateFields(cfg.Scripts[i])
+				if invalid == nil && cfg.Scripts[i].Template && (flags.Template != nil || flags.Params != nil) {
+					invalid = checkTemplateContent(cfg.Scripts[i])
+				}
+				if invalid != nil {
+					return invalid
+				}
+
+				updated = cfg.Scripts[i]
+				return nil
 			}
+		}
+		return errScriptNotFound
+	})
+	if err == errScriptNotFound {
+		return c.Status(404).JSON(fiber.Map{"error": "Script not found"})
+	}
+	if err == errConfigChanged {
+		return c.Status(409).JSON(fiber.Map{"error": "Config file changed on disk. Reload and try again."})
+	}
+	if invalid != nil {
+		return c.Status(400).JSON(fiber.Map{"error": invalid.Error()})
+	}
+	if errors.Is(err, errInvalidConfig) {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save config"})
+	}
 
-			return c.JSON(config.Scripts[i])
+	// If type or redirect changed, update the Caddy route
+	if old.Type == "redirect" && updated.Type != "redirect" {
+		if err := removeCaddyRedirect(name); err != nil {
+			log.Printf("Failed to remove Caddy redirect route: %v", err)
+		}
+	}
+	if updated.Type == "redirect" && updated.RedirectURL != "" &&
+		(old.Type != "redirect" || updated.RedirectURL != old.RedirectURL) {
+		if err := upsertCaddyRedirect(name, updated.RedirectURL); err != nil {
+			log.Printf("Failed to update Caddy redirect route: %v", err)
 		}
+		healthChecker.Trigger()
 	}
+	if old.Type == "mirror" && updated.Type != "mirror" {
+		mirrorCache.Remove(name)
+	}
+	if updated.Type == "mirror" && (old.Type != "mirror" || updated.RedirectURL != old.RedirectURL) {
+		if _, err := mirrorCache.Refresh(updated); err != nil {
+			log.Printf("Failed to fetch mirror %s: %v", name, err)
+		}
+	}
+
+	recordAudit(c, AuditEntry{Action: "script.update", Script: name, Before: scriptPtr(old), After: scriptPtr(updated)})
+
 	updateIndexPageWithCurrentScripts()
 
-	return c.Status(404).JSON(fiber.Map{"error": "Script not found"})
+	return c.JSON(updated)
 }
 
 func deleteScriptAPI(c *fiber.Ctx) error {
 	name := c.Params("name")
 
-	for i, script := range config.Scripts {
-		if script.Name == name {
-			// Remove from config
-			config.Scripts = append(config.Scripts[:i], config.Scripts[i+1:]...)
-			if err := saveConfig(); err != nil {
-				return c.Status(500).JSON(fiber.Map{"error": "Failed to save config"})
+	var script ScriptConfig
+	err := configStore.Update(func(cfg *Config) error {
+		for i, existing := range cfg.Scripts {
+			if existing.Name == name {
+				script = existing
+				cfg.Scripts = append(cfg.Scripts[:i], cfg.Scripts[i+1:]...)
+				return nil
 			}
+		}
+		return errScriptNotFound
+	})
+	if err == errScriptNotFound {
+		return c.Status(404).JSON(fiber.Map{"error": "Script not found"})
+	}
+	if err == errConfigChanged {
+		return c.Status(409).JSON(fiber.Map{"error": "Config file changed on disk. Reload and try again."})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save config"})
+	}
 
-			// Remove script directory if local type
-			if script.Type == "local" {
-				scriptDir := filepath.Join(scriptsPath, script.Name)
-				os.RemoveAll(scriptDir)
-			}
+	entry := AuditEntry{Action: "script.delete", Script: script.Name, Before: scriptPtr(script)}
+	if hash, ok := scriptChecksum(script); ok {
+		entry.BeforeSHA256 = hash
+	}
 
-			// Remove redirect from Caddyfile if redirect type
-			if script.Type == "redirect" {
-				if err := removeCaddyfileRedirect(script.Name); err != nil {
-					log.Printf("Failed to remove Caddyfile redirect: %v", err)
-				}
-				if err := reloadCaddy(); err != nil {
-					log.Printf("Failed to reload Caddy: %v", err)
-				}
-			}
+	// Remove script directory if local type
+	if script.Type == "local" {
+		scriptDir := filepath.Join(scriptsPath, script.Name)
+		os.RemoveAll(scriptDir)
+	}
+	// Audited once the files are gone, so the git commit includes their removal
+	recordAudit(c, entry)
 
-			return c.JSON(fiber.Map{"message": "Script deleted successfully"})
+	// Remove the Caddy route if redirect type
+	if script.Type == "redirect" {
+		if err := removeCaddyRedirect(script.Name); err != nil {
+			log.Printf("Failed to remove Caddy redirect route: %v", err)
 		}
 	}
+
+	statsStore.Delete(script.Name)
+	removeSignature(script.Name)
+	removeDraft(script.Name)
+	if script.Type == "mirror" {
+		mirrorCache.Remove(script.Name)
+	}
+
 	updateIndexPageWithCurrentScripts()
 
-	return c.Status(404).JSON(fiber.Map{"error": "Script not found"})
+	return c.JSON(fiber.Map{"message": "Script deleted successfully"})
 }
 
 func getScriptContentAPI(c *fiber.Ctx) error {
 	name := c.Params("name")
 
-	for _, script := range config.Scripts {
-		if script.Name == name && script.Type == "local" {
-			scriptFile := filepath.Join(scriptsPath, script.Name, fmt.Sprintf("runme_%s.sh", script.Name))
-			content, err := os.ReadFile(scriptFile)
-			if err != nil {
-				return c.Status(404).JSON(fiber.Map{"error": "Script file not found"})
-			}
+	script, ok := findLocalScript(name)
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Script not found or not local"})
+	}
 
-			return c.JSON(fiber.Map{"content": string(content)})
-		}
+	content, err := os.ReadFile(localScriptFile(script))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Script file not found"})
 	}
 
-	return c.Status(404).JSON(fiber.Map{"error": "Script not found or not local"})
+	return c.JSON(fiber.Map{"content": string(content)})
 }
 
 func updateScriptContentAPI(c *fiber.Ctx) error {
@@ -448,19 +834,68 @@ func updateScriptContentAPI(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
 
-	for _, script := range config.Scripts {
-		if script.Name == name && script.Type == "local" {
-			scriptFile := filepath.Join(scriptsPath, script.Name, fmt.Sprintf("runme_%s.sh", script.Name))
+	script, ok := findLocalScript(name)
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Script not found or not local"})
+	}
+	if script.Git != nil {
+		return gitManagedError(c, script)
+	}
 
-			if err := os.WriteFile(scriptFile, []byte(body.Content), 0755); err != nil {
-				return c.Status(500).JSON(fiber.Map{"error": "Failed to save script content"})
-			}
+	// Broken scripts would be live for everyone at once; refuse to save them
+	issues := lintScriptContent(script, []byte(body.Content))
+	if lintHasErrors(issues) {
+		return c.Status(422).JSON(fiber.Map{
+			"error":  lintErrorMessage(issues),
+			"issues": issues,
+		})
+	}
+
+	// Scripts are public: keep secrets and destructive commands out unless an
+	// admin explicitly overrides
+	findings := scanContent([]byte(body.Content))
+	if len(findings) > 0 && !scanOverride(c) {
+		return scanBlockedResponse(c, findings)
+	}
 
-			return c.JSON(fiber.Map{"message": "Script content updated successfully"})
+	// With review enabled the change waits as a draft for someone else to
+	// approve it
+	if reviewEnabled {
+		draft, err := saveDraft(script, []byte(body.Content), currentUser(c), "")
+		if err != nil {
+			return draftSaveError(c, script.Name, err)
 		}
+		recordAudit(c, AuditEntry{Action: "script.draft", Script: script.Name, AfterSHA256: draft.SHA256})
+		auditScanOverride(c, script.Name, findings)
+		return c.JSON(fiber.Map{
+			"message":  "Draft saved and waiting for review",
+			"draft":    draft,
+			"warnings": issues,
+		})
 	}
 
-	return c.Status(404).JSON(fiber.Map{"error": "Script not found or not local"})
+	rev, previousHash, err := writeScriptContent(script, []byte(body.Content), currentUser(c), "", "")
+	if err != nil {
+		log.Printf("Failed to save content for %s: %v", script.Name, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save script content"})
+	}
+
+	recordAudit(c, AuditEntry{
+		Action:       "script.content",
+		Script:       script.Name,
+		BeforeSHA256: previousHash,
+		AfterSHA256:  rev.SHA256,
+	})
+	auditScanOverride(c, script.Name, findings)
+
+	// The index embeds each script's checksum
+	updateIndexPageWithCurrentScripts()
+
+	return c.JSON(fiber.Map{
+		"message":  "Script content updated successfully",
+		"revision": rev,
+		"warnings": issues,
+	})
 }
 
 func updateIndexPageAPI(c *fiber.Ctx) error {
@@ -470,30 +905,64 @@ func updateIndexPageAPI(c *fiber.Ctx) error {
 	}
 
 	// Generate new index.html
-	htmlContent := generateIndexHTML(data.Scripts)
+	htmlContent := generateIndexHTML(indexScripts(data.Scripts))
 
 	indexPath := filepath.Join(scriptsPath, "index.html")
 	if err := os.WriteFile(indexPath, []byte(htmlContent), 0644); err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update index page"})
 	}
 
+	recordAudit(c, AuditEntry{Action: "index.update", AfterSHA256: contentHash([]byte(htmlContent))})
+
 	return c.JSON(fiber.Map{"message": "Index page updated successfully"})
 }
 
 func getIndexPageAPI(c *fiber.Ctx) error {
-	return c.JSON(IndexPageData{Scripts: config.Scripts})
+	return c.JSON(IndexPageData{Scripts: configStore.Scripts()})
 }
 
 func generateIndexHTML(scripts []ScriptConfig) string {
 	var scriptElements strings.Builder
 
-	for _, script := range scripts {
-		scriptElements.WriteString(fmt.Sprintf(`        <div class="endpoint" data-script="%s">
-            <span class="emoji">%s</span>/%s - %s
+	for _, group := range groupByCategory(scripts) {
+		scriptElements.WriteString(fmt.Sprintf(`        <div class="category">
+        <h2>%s</h2>
+`, escapeHTML(group.Name)))
+
+		for _, script := range group.Scripts {
+			// Local scripts get a checksum-pinned variant of the install command;
+			// templates have one checksum per variant
+			pinned := ""
+			if hash, ok := scriptChecksum(script); ok && !script.Template {
+				pinned = fmt.Sprintf(` data-sha256="%s"`, hash)
+			}
+			pinButton := ""
+			if pinned != "" {
+				pinButton = `<span class="pin" title="Copy a command that checks the SHA-256 before running">🔒 pinned</span>`
+			}
+
+			tagLabels := ""
+			for _, tag := range script.Tags {
+				tagLabels += fmt.Sprintf(` <span class="tag">#%s</span>`, escapeHTML(tag))
+			}
+			// The search box matches against name, description, category and tags
+			search := strings.ToLower(strings.Join(append([]string{script.Name, script.Description, script.Category}, script.Tags...), " "))
+
+			scriptElements.WriteString(fmt.Sprintf(`        <div class="endpoint" data-script="%s"%s data-tags="%s" data-search="%s">
+            <span class="emoji">%s</span>/%s - %s %s%s
             <div class="copy-feedback">Copied!</div>
         </div>
         
-`, script.Name, script.Icon, script.Name, script.Description))
+`, escapeHTML(script.Name), pinned, escapeHTML(strings.Join(script.Tags, " ")), escapeHTML(search),
+				escapeHTML(script.Icon), escapeHTML(script.Name), escapeHTML(script.Description), pinButton, tagLabels))
+		}
+
+		scriptElements.WriteString("        </div>\n\n")
+	}
+
+	var tagFilters strings.Builder
+	for _, tag := range allTags(scripts) {
+		tagFilters.WriteString(fmt.Sprintf(`<button class="tag-filter" data-tag="%s">#%s</button>`, escapeHTML(tag), escapeHTML(tag)))
 	}
 
 	// Return the complete HTML template with all styling and JavaScript
@@ -501,6 +970,7 @@ func generateIndexHTML(scripts []ScriptConfig) string {
 <html>
 <head>
     <title>Script Server</title>
+    <link rel="alternate" type="application/atom+xml" title="Script updates" href="/feed.atom">
     <meta name="viewport" content="width=device-width, initial-scale=1">
     <style>
         body {
@@ -559,6 +1029,18 @@ func generateIndexHTML(scripts []ScriptConfig) string {
         .copy-feedback.show {
             opacity: 1;
         }
+        .pin {
+            font-size: 12px;
+            color: #8b949e;
+            border: 1px solid #30363d;
+            border-radius: 4px;
+            padding: 1px 6px;
+            margin-left: 8px;
+        }
+        .pin:hover {
+            color: #f0f6fc;
+            border-color: #58a6ff;
+        }
         .usage {
             background: #0d1117;
             border: 1px solid #30363d;
@@ -596,6 +1078,55 @@ func generateIndexHTML(scripts []ScriptConfig) string {
         .emoji { 
             margin-right: 8px; 
         }
+        .search {
+            width: 100%%;
+            box-sizing: border-box;
+            padding: 10px 14px;
+            margin: 20px 0 10px;
+            font-family: inherit;
+            font-size: 16px;
+            color: #c9d1d9;
+            background: #161b22;
+            border: 1px solid #30363d;
+            border-radius: 8px;
+        }
+        .search:focus {
+            outline: none;
+            border-color: #58a6ff;
+        }
+        .tag-filters {
+            margin-bottom: 10px;
+        }
+        .tag-filter {
+            font-family: inherit;
+            font-size: 12px;
+            color: #8b949e;
+            background: #161b22;
+            border: 1px solid #30363d;
+            border-radius: 10px;
+            padding: 2px 10px;
+            margin: 0 6px 6px 0;
+            cursor: pointer;
+        }
+        .tag-filter.active {
+            color: #0d1117;
+            background: #58a6ff;
+            border-color: #58a6ff;
+        }
+        .category h2 {
+            color: #ffa657;
+            font-size: 18px;
+            margin: 30px 0 10px;
+        }
+        .tag {
+            font-size: 12px;
+            color: #8b949e;
+            margin-left: 4px;
+        }
+        .no-results {
+            color: #8b949e;
+            display: none;
+        }
         .click-hint {
             font-size: 12px;
             color: #8b949e;
@@ -625,8 +1156,11 @@ func generateIndexHTML(scripts []ScriptConfig) string {
         <h1><span class="emoji">🚀</span>Script Server</h1>
         <p>Available script endpoints:</p>
         <div class="click-hint">💡 Click any endpoint to copy the curl command to clipboard</div>
+        <input type="search" id="search" class="search" placeholder="Search scripts..." autocomplete="off">
+        <div class="tag-filters">%s</div>
         
 %s
+        <p id="no-results" class="no-results">No scripts match your search.</p>
         <div class="usage">
             <h3><span class="emoji">📖</span>Usage Examples</h3>
             <p>Direct download:</p>
@@ -635,6 +1169,13 @@ func generateIndexHTML(scripts []ScriptConfig) string {
             <p><code>curl -fsSL [your-domain]/scriptname | sudo bash</code></p>
             <p>Save to file:</p>
             <p><code>curl -o script.sh [your-domain]/scriptname</code></p>
+            <p>Verify the checksum, then execute (or click 🔒 pinned on a script for a command with its hash built in):</p>
+            <p><code>curl -fsSLO [your-domain]/scriptname && curl -fsSL [your-domain]/scriptname.sha256 | sha256sum -c - && sudo bash scriptname</code></p>
+            <p>Checksums for all scripts: <code>curl [your-domain]/SHA256SUMS</code></p>
+            <p>Script catalog for tools: <code>curl [your-domain]/catalog.json</code> (or <code>catalog.yaml</code>)</p>
+            <p>Follow changes in a feed reader: <code>[your-domain]/feed.atom</code>, or <code>[your-domain]/feed/scriptname.atom</code> for one script</p>
+            <p>Verify the signature before running (needs <a href="https://jedisct1.github.io/minisign/" style="color: #58a6ff;">minisign</a>):</p>
+            <p><code>curl -fsSLO [your-domain]/scriptname && curl -fsSL [your-domain]/scriptname.sig -o scriptname.minisig && curl -fsSL [your-domain]/.well-known/minisign.pub -o script-server.pub && minisign -Vm scriptname -p script-server.pub && sudo bash scriptname</code></p>
         </div>
         
         <div class="health">
@@ -658,13 +1199,24 @@ func generateIndexHTML(scripts []ScriptConfig) string {
             endpoint.addEventListener('click', function(e) {
                 e.preventDefault();
                 const script = this.dataset.script;
-                const command = 'curl -fsSL ' + currentDomain + '/' + script + ' | sudo bash';
+                let command = 'curl -fsSL ' + currentDomain + '/' + script + ' | sudo bash';
+                if (e.target.classList.contains('pin')) {
+                    command = pinnedCommand(script, this.dataset.sha256);
+                }
                 
                 copyToClipboard(command);
                 showFeedback(this);
             });
         });
 
+        // Download to a temp file and only run it if the hash matches the one
+        // published when this page was generated
+        function pinnedCommand(script, sha256) {
+            return 'f=$(mktemp) && curl -fsSL ' + currentDomain + '/' + script + ' -o "$f"' +
+                ' && echo "' + sha256 + '  $f" | sha256sum -c --quiet -' +
+                ' && sudo bash "$f"; rm -f "$f"';
+        }
+
         function copyToClipboard(text) {
             if (navigator.clipboard && window.isSecureContext) {
                 navigator.clipboard.writeText(text).then(() => {
@@ -718,6 +1270,42 @@ func generateIndexHTML(scripts []ScriptConfig) string {
             }, 2000);
         }
 
+        // Show the scripts matching the search box and all selected tags,
+        // hiding categories left empty
+        const activeTags = new Set();
+
+        function filterScripts() {
+            const query = document.getElementById('search').value.trim().toLowerCase();
+            let visible = 0;
+            document.querySelectorAll('.category').forEach(category => {
+                let shown = 0;
+                category.querySelectorAll('.endpoint[data-script]').forEach(endpoint => {
+                    const tags = endpoint.dataset.tags.split(' ');
+                    const match = endpoint.dataset.search.includes(query) &&
+                        [...activeTags].every(tag => tags.includes(tag));
+                    endpoint.style.display = match ? '' : 'none';
+                    if (match) shown++;
+                });
+                category.style.display = shown ? '' : 'none';
+                visible += shown;
+            });
+            document.getElementById('no-results').style.display = visible ? 'none' : 'block';
+        }
+
+        document.getElementById('search').addEventListener('input', filterScripts);
+        document.querySelectorAll('.tag-filter').forEach(button => {
+            button.addEventListener('click', function() {
+                const tag = this.dataset.tag;
+                if (activeTags.has(tag)) {
+                    activeTags.delete(tag);
+                } else {
+                    activeTags.add(tag);
+                }
+                this.classList.toggle('active');
+                filterScripts();
+            });
+        });
+
         function copyHealthCheck() {
             copyToClipboard('curl ' + currentDomain + '/health');
         }
@@ -727,7 +1315,7 @@ func generateIndexHTML(scripts []ScriptConfig) string {
             const codeElements = document.querySelectorAll('.usage code');
             codeElements.forEach(code => {
                 let text = code.textContent;
-                text = text.replace('[your-domain]', currentDomain);
+                text = text.split('[your-domain]').join(currentDomain);
                 code.textContent = text;
                 
                 // Add click to copy functionality
@@ -738,7 +1326,7 @@ func generateIndexHTML(scripts []ScriptConfig) string {
         });
     </script>
 </body>
-</html>`, scriptElements.String())
+</html>`, tagFilters.String(), scriptElements.String())
 }
 
 func browseFilesAPI(c *fiber.Ctx) error {
@@ -807,96 +1395,9 @@ func isExecutable(path string) bool {
 	return info.Mode()&0111 != 0
 }
 
-// Update Caddyfile to add a redirect handler for a script
-func updateCaddyfileRedirect(scriptName, redirectURL string) error {
-	caddyfilePath := "/app/Caddyfile" // Path inside admin-dashboard container
-	// Read the Caddyfile
-	content, err := os.ReadFile(caddyfilePath)
-	if err != nil {
-		return err
-	}
-	caddy := string(content)
-
-	// Remove any existing handler for this script
-	start := fmt.Sprintf("\thandle /%s {", scriptName)
-	end := "}\n"
-	startIdx := strings.Index(caddy, start)
-	if startIdx != -1 {
-		endIdx := strings.Index(caddy[startIdx:], end)
-		if endIdx != -1 {
-			caddy = caddy[:startIdx] + caddy[startIdx+endIdx+len(end):]
-		}
-	}
-
-	// Insert new handler before @script_request
-	insertPoint := strings.Index(caddy, "# Handle other script requests with clean URLs")
-	if insertPoint == -1 {
-		insertPoint = len(caddy)
-	}
-	redirectBlock := fmt.Sprintf("\thandle /%s {\n\t\tredir %s 302\n\t}\n\n", scriptName, redirectURL)
-	caddy = caddy[:insertPoint] + redirectBlock + caddy[insertPoint:]
-
-	// Write back
-	if err := os.WriteFile(caddyfilePath, []byte(caddy), 0644); err != nil {
-		return err
-	}
-
-	return reloadCaddy()
-}
-
-// Remove a redirect handler from the Caddyfile
-func removeCaddyfileRedirect(scriptName string) error {
-	caddyfilePath := "/app/Caddyfile"
-	content, err := os.ReadFile(caddyfilePath)
-	if err != nil {
-		return err
-	}
-	caddy := string(content)
-
-	start := fmt.Sprintf("\thandle /%s {", scriptName)
-	end := "}\n"
-	startIdx := strings.Index(caddy, start)
-	if startIdx != -1 {
-		endIdx := strings.Index(caddy[startIdx:], end)
-		if endIdx != -1 {
-			caddy = caddy[:startIdx] + caddy[startIdx+endIdx+len(end):]
-		}
-	}
-
-	return os.WriteFile(caddyfilePath, []byte(caddy), 0644)
-}
-
-// Reload Caddy via its admin API
-func reloadCaddy() error {
-	caddyfilePath := "/app/Caddyfile"
-	caddyAPI := "http://script-server:2019/load" // Use service name from docker-compose
-
-	caddyfile, err := os.ReadFile(caddyfilePath)
-	if err != nil {
-		return err
-	}
-
-	req, err := http.NewRequest("POST", caddyAPI, bytes.NewReader(caddyfile))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "text/caddyfile")
-
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 300 {
-		return fmt.Errorf("Caddy reload failed: %s", resp.Status)
-	}
-	return nil
-}
-
 func updateIndexPageWithCurrentScripts() error {
-	htmlContent := generateIndexHTML(config.Scripts)
+	scripts := indexScripts(configStore.Scripts())
+	htmlContent := generateIndexHTML(scripts)
 
 	indexPath := filepath.Join(scriptsPath, "index.html")
 	if err := os.WriteFile(indexPath, []byte(htmlContent), 0644); err != nil {
@@ -904,6 +1405,6 @@ func updateIndexPageWithCurrentScripts() error {
 		return err
 	}
 
-	log.Printf("Index page auto-updated with %d scripts", len(config.Scripts))
+	log.Printf("Index page auto-updated with %d scripts", len(scripts))
 	return nil
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+)
+
+// testUsersConfig has a user of every role, all with the password admin123
+const testUsersConfig = `users:
+  - username: admin
+    password_hash: "$2a$10$z.oGWDtg8Ah3kuOt6IKZW.vdvcDKVm1aVueIVUiDKFiu/aAV3nOuy"
+    role: admin
+  - username: editor
+    password_hash: "$2a$10$z.oGWDtg8Ah3kuOt6IKZW.vdvcDKVm1aVueIVUiDKFiu/aAV3nOuy"
+    role: editor
+  - username: viewer
+    password_hash: "$2a$10$z.oGWDtg8Ah3kuOt6IKZW.vdvcDKVm1aVueIVUiDKFiu/aAV3nOuy"
+    role: viewer
+`
+
+// testServer serves the admin server's routes from temporary directories.
+// Requests authenticate with API tokens, as the dashboard's session cookie
+// needs a login first.
+type testServer struct {
+	t   *testing.T
+	app *fiber.App
+	dir string
+}
+
+// swapGlobal sets a package variable for the duration of a test
+func swapGlobal[T any](t *testing.T, p *T, value T) {
+	old := *p
+	*p = value
+	t.Cleanup(func() { *p = old })
+}
+
+// newTestServer starts a server whose config.yaml holds testUsersConfig
+// followed by extra
+func newTestServer(t *testing.T, extra string) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+	for _, sub := range []string{"scripts", "data"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	configPath := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte(testUsersConfig+extra), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	swapGlobal(t, &scriptsPath, filepath.Join(dir, "scripts"))
+	swapGlobal(t, &dataPath, filepath.Join(dir, "data"))
+	swapGlobal(t, &configStore, newConfigStore(configPath))
+	if err := configStore.Load(); err != nil {
+		t.Fatal(err)
+	}
+	swapGlobal(t, &caddyEnabled, false)
+	swapGlobal(t, &reviewEnabled, false)
+	swapGlobal(t, &hideBrokenScripts, false)
+	swapGlobal(t, &gitRepo, nil)
+	swapGlobal(t, &signingKey, nil)
+	swapGlobal(t, &signingKeyID, [8]byte{})
+	swapGlobal(t, &store, session.New())
+	swapGlobal(t, &tokenStore, newTokenStore(filepath.Join(dataPath, "tokens.json")))
+	swapGlobal(t, &statsStore, newStatsStore(filepath.Join(dataPath, "stats.json")))
+	swapGlobal(t, &sourceSyncer, newSourceSyncer(filepath.Join(dataPath, "sources.json")))
+	swapGlobal(t, &mirrorCache, newMirrorCache(filepath.Join(dataPath, "mirrors")))
+	swapGlobal(t, &healthChecker, newHealthChecker(filepath.Join(dataPath, "health.json")))
+	swapGlobal(t, &webhooks, newWebhookDispatcher(filepath.Join(dataPath, "webhooks")))
+	swapGlobal(t, &feeds, &feedCache{summaries: map[string]string{}})
+
+	app := fiber.New()
+	setupRoutes(app)
+	return &testServer{t: t, app: app, dir: dir}
+}
+
+// token creates an API token for a user and returns its secret
+func (s *testServer) token(owner, scope string) string {
+	s.t.Helper()
+	_, secret, err := tokenStore.Create(owner, "test", scope, nil)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return secret
+}
+
+// request sends a request with an optional bearer token and JSON body, and
+// returns the status code and response body
+func (s *testServer) request(method, path, secret string, body any) (int, []byte) {
+	s.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, path, reader)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	return s.do(req)
+}
+
+func (s *testServer) do(req *http.Request) (int, []byte) {
+	s.t.Helper()
+	resp, err := s.app.Test(req, -1)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return resp.StatusCode, data
+}
+
+// writeScript writes the content of the local script name
+func (s *testServer) writeScript(name, content string) {
+	s.t.Helper()
+	if err := os.WriteFile(filepath.Join(scriptsPath, name+".sh"), []byte(content), 0644); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+func decodeJSON(t *testing.T, data []byte, v any) {
+	t.Helper()
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("decode %s: %v", data, err)
+	}
+}
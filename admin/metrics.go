@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/subtle"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "script_admin_http_requests_total",
+		Help: "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "script_admin_http_request_duration_seconds",
+		Help:    "HTTP request latency by method and route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	scriptDownloadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "script_admin_script_downloads_total",
+		Help: "Script downloads by script name.",
+	}, []string{"script"})
+
+	caddyOperationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "script_admin_caddy_operations_total",
+		Help: "Caddy admin API operations on redirect routes by operation and result.",
+	}, []string{"operation", "result"})
+
+	configSaveErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "script_admin_config_save_errors_total",
+		Help: "Failed config.yaml saves by reason.",
+	}, []string{"reason"})
+
+	mirrorUpstreamChangesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "script_admin_mirror_upstream_changes_total",
+		Help: "Unexpected upstream content changes of mirror scripts by script name.",
+	}, []string{"script"})
+
+	loginFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "script_admin_login_failures_total",
+		Help: "Rejected logins by method (password or token).",
+	}, []string{"method"})
+)
+
+func init() {
+	prometheus.MustRegister(scriptsCollector{
+		desc: prometheus.NewDesc("script_admin_scripts", "Configured scripts by type.", []string{"type"}, nil),
+	})
+}
+
+// scriptsCollector reports the configured scripts at scrape time so the
+// gauge follows hot reloads without extra bookkeeping
+type scriptsCollector struct {
+	desc *prometheus.Desc
+}
+
+func (s scriptsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.desc
+}
+
+func (s scriptsCollector) Collect(ch chan<- prometheus.Metric) {
+	if configStore == nil {
+		return
+	}
+	counts := map[string]int{"local": 0, "redirect": 0, "mirror": 0}
+	for _, script := range configStore.Scripts() {
+		scriptType := script.Type
+		if scriptType == "" {
+			scriptType = "local"
+		}
+		counts[scriptType]++
+	}
+	for scriptType, count := range counts {
+		ch <- prometheus.MustNewConstMetric(s.desc, prometheus.GaugeValue, float64(count), scriptType)
+	}
+}
+
+// metricsMiddleware records the count and latency of every request, labelled
+// with the matched route pattern rather than the raw path to keep the number
+// of series bounded
+func metricsMiddleware(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	if fiberErr, ok := err.(*fiber.Error); ok {
+		status = fiberErr.Code
+	} else if err != nil {
+		status = fiber.StatusInternalServerError
+	}
+
+	// Fiber strings point into reused buffers; labels outlive the request
+	method := strings.Clone(c.Method())
+	route := c.Route().Path
+	httpRequestsTotal.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	httpRequestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// recordCaddyOperation counts the outcome of a Caddy admin API operation
+func recordCaddyOperation(operation string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	caddyOperationsTotal.WithLabelValues(operation, result).Inc()
+}
+
+var metricsHandler = adaptor.HTTPHandler(promhttp.Handler())
+
+// metricsEndpoint serves Prometheus metrics. When METRICS_TOKEN is set,
+// scrapers must send it as a bearer token.
+func metricsEndpoint(c *fiber.Ctx) error {
+	if token := os.Getenv("METRICS_TOKEN"); token != "" {
+		if subtle.ConstantTimeCompare([]byte(bearerToken(c)), []byte(token)) != 1 {
+			return c.Status(401).JSON(fiber.Map{"error": "Authentication required"})
+		}
+	}
+	return metricsHandler(c)
+}
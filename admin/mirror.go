@@ -0,0 +1,429 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Mirror scripts are served from a cached copy of their upstream URL
+// (redirect_url), so installs keep working while the upstream is down and
+// cannot change underneath users unnoticed. The cache is refreshed with
+// conditional requests. A changed upstream is reported, and with
+// pin_upstream set it is held until someone accepts it.
+
+const maxMirrorSize = 10 << 20
+
+// mirrorChangeHeld is the audit detail of upstream changes that are not
+// served yet
+const mirrorChangeHeld = "upstream changed; new content held until accepted"
+
+// MirrorStatus describes the cached copy of a mirror script
+type MirrorStatus struct {
+	URL           string     `json:"url"`
+	SHA256        string     `json:"sha256,omitempty"`
+	Size          int64      `json:"size"`
+	ETag          string     `json:"etag,omitempty"`
+	LastModified  string     `json:"last_modified,omitempty"`
+	FetchedAt     *time.Time `json:"fetched_at,omitempty"`
+	CheckedAt     *time.Time `json:"checked_at,omitempty"`
+	PendingSHA256 string     `json:"pending_sha256,omitempty"`
+	PendingSince  *time.Time `json:"pending_since,omitempty"`
+	Error         string     `json:"error,omitempty"`
+}
+
+// MirrorCache refreshes mirror scripts and stores them in DATA_PATH/mirrors
+type MirrorCache struct {
+	mu      sync.Mutex
+	dir     string
+	client  *http.Client
+	trigger chan struct{}
+}
+
+var mirrorCache *MirrorCache
+
+func newMirrorCache(dir string) *MirrorCache {
+	return &MirrorCache{
+		dir:     dir,
+		client:  newMirrorClient(os.Getenv("MIRROR_ALLOW_PRIVATE") == "true"),
+		trigger: make(chan struct{}, 1),
+	}
+}
+
+// newMirrorClient returns the client upstreams are fetched with. Unless
+// allowPrivate is set it refuses to connect to addresses that are not
+// publicly routable. The check runs on the address actually dialed, so
+// neither DNS answers nor redirects can point a fetch at internal services.
+func newMirrorClient(allowPrivate bool) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !allowPrivate {
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: publicOnlyControl}
+		transport.DialContext = dialer.DialContext
+	}
+	return &http.Client{Timeout: 30 * time.Second, Transport: transport}
+}
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func publicOnlyControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("%s is not a public address", host)
+	}
+	return nil
+}
+
+// publicIP reports whether ip is routable on the internet
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || sharedAddressSpace.Contains(ip))
+}
+
+func (m *MirrorCache) contentFile(name string) string {
+	return filepath.Join(m.dir, name+".sh")
+}
+
+func (m *MirrorCache) pendingFile(name string) string {
+	return filepath.Join(m.dir, name+".pending")
+}
+
+func (m *MirrorCache) statusFile(name string) string {
+	return filepath.Join(m.dir, name+".json")
+}
+
+// Status returns the cache status of a mirror script
+func (m *MirrorCache) Status(name string) (MirrorStatus, bool) {
+	data, err := os.ReadFile(m.statusFile(name))
+	if err != nil {
+		return MirrorStatus{}, false
+	}
+	var status MirrorStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		log.Printf("Failed to parse mirror status of %s: %v", name, err)
+		return MirrorStatus{}, false
+	}
+	return status, true
+}
+
+func (m *MirrorCache) saveStatus(name string, status MirrorStatus) error {
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(m.statusFile(name), data, 0644)
+}
+
+// Remove deletes the cached copy of a script
+func (m *MirrorCache) Remove(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, path := range []string{m.contentFile(name), m.pendingFile(name), m.statusFile(name)} {
+		os.Remove(path)
+	}
+}
+
+// Trigger asks Run to refresh now without waiting for the interval
+func (m *MirrorCache) Trigger() {
+	select {
+	case m.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run refreshes all mirror scripts at start, every interval and on Trigger
+func (m *MirrorCache) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		updated := false
+		for _, script := range configStore.Scripts() {
+			if script.Type != "mirror" {
+				continue
+			}
+			changed, err := m.Refresh(script)
+			if err != nil {
+				log.Printf("Failed to refresh mirror %s: %v", script.Name, err)
+			}
+			updated = updated || changed
+		}
+		// The index embeds each script's checksum
+		if updated {
+			updateIndexPageWithCurrentScripts()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-m.trigger:
+		}
+	}
+}
+
+// Refresh revalidates the cached copy against the upstream URL and reports
+// whether the served content changed. Fetch errors keep the cached copy in
+// service and are recorded in the status.
+func (m *MirrorCache) Refresh(script ScriptConfig) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return false, err
+	}
+
+	status, cached := m.Status(script.Name)
+	// A new URL was chosen on purpose, so its content is not a surprise
+	sameURL := cached && status.URL == script.RedirectURL && status.SHA256 != ""
+	now := time.Now().UTC()
+	status.CheckedAt = &now
+
+	content, etag, lastModified, err := m.fetch(script.RedirectURL, status, sameURL)
+	if err != nil {
+		status.Error = err.Error()
+		if saveErr := m.saveStatus(script.Name, status); saveErr != nil {
+			log.Printf("Failed to save mirror status of %s: %v", script.Name, saveErr)
+		}
+		return false, err
+	}
+	status.Error = ""
+	if content == nil {
+		// 304 Not Modified
+		return false, m.saveStatus(script.Name, status)
+	}
+
+	hash := contentHash(content)
+	status.ETag, status.LastModified = etag, lastModified
+
+	switch {
+	case sameURL && hash == status.SHA256:
+		status.PendingSHA256, status.PendingSince = "", nil
+		os.Remove(m.pendingFile(script.Name))
+		return false, m.saveStatus(script.Name, status)
+
+	case sameURL && script.PinUpstream:
+		if hash == status.PendingSHA256 {
+			return false, m.saveStatus(script.Name, status)
+		}
+		if err := writeFileAtomic(m.pendingFile(script.Name), content, 0644); err != nil {
+			return false, err
+		}
+		status.PendingSHA256, status.PendingSince = hash, &now
+		log.Printf("Upstream of mirror %s changed to %s; holding it until accepted", script.Name, hash)
+		reportMirrorChange(script, status.SHA256, hash, mirrorChangeHeld)
+		return false, m.saveStatus(script.Name, status)
+	}
+
+	if sameURL {
+		log.Printf("Upstream of mirror %s changed to %s", script.Name, hash)
+		reportMirrorChange(script, status.SHA256, hash, "upstream changed; now serving the new content")
+	}
+	status.URL = script.RedirectURL
+	return true, m.store(script.Name, content, status)
+}
+
+// fetch downloads url. It returns nil content when a conditional request
+// finds the cached copy still current.
+func (m *MirrorCache) fetch(url string, status MirrorStatus, conditional bool) ([]byte, string, string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	req.Header.Set("User-Agent", "script-admin-mirror")
+	if conditional && status.PendingSHA256 == "" {
+		if status.ETag != "" {
+			req.Header.Set("If-None-Match", status.ETag)
+		}
+		if status.LastModified != "" {
+			req.Header.Set("If-Modified-Since", status.LastModified)
+		}
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, "", "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", "", fmt.Errorf("upstream returned %s", resp.Status)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxMirrorSize+1))
+	if err != nil {
+		return nil, "", "", err
+	}
+	if len(content) > maxMirrorSize {
+		return nil, "", "", fmt.Errorf("upstream content is larger than %d bytes", maxMirrorSize)
+	}
+	return content, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), nil
+}
+
+// store makes content the served copy. The caller must hold m.mu.
+func (m *MirrorCache) store(name string, content []byte, status MirrorStatus) error {
+	if err := writeFileAtomic(m.contentFile(name), content, 0644); err != nil {
+		return err
+	}
+	if err := signScript(name, content); err != nil {
+		log.Printf("Failed to sign %s: %v", name, err)
+	}
+
+	now := time.Now().UTC()
+	status.SHA256 = contentHash(content)
+	status.Size = int64(len(content))
+	status.FetchedAt = &now
+	status.PendingSHA256, status.PendingSince = "", nil
+	os.Remove(m.pendingFile(name))
+	return m.saveStatus(name, status)
+}
+
+var (
+	errNoPendingChange = errors.New("no pending upstream change")
+	errMirrorChanged   = errors.New("upstream changed again since it was reviewed")
+)
+
+// Accept serves the held upstream change of a pinned mirror. When sha256 is
+// given it must match the held content.
+func (m *MirrorCache) Accept(name, sha256 string) (MirrorStatus, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status, ok := m.Status(name)
+	if !ok || status.PendingSHA256 == "" {
+		return status, "", errNoPendingChange
+	}
+	if sha256 != "" && sha256 != status.PendingSHA256 {
+		return status, "", errMirrorChanged
+	}
+	content, err := os.ReadFile(m.pendingFile(name))
+	if err != nil {
+		return status, "", err
+	}
+
+	previousHash := status.SHA256
+	if err := m.store(name, content, status); err != nil {
+		return status, "", err
+	}
+	status, _ = m.Status(name)
+	return status, previousHash, nil
+}
+
+// reportMirrorChange records an unexpected upstream change
+func reportMirrorChange(script ScriptConfig, before, after, details string) {
+	mirrorUpstreamChangesTotal.WithLabelValues(script.Name).Inc()
+	writeAuditEntry(AuditEntry{
+		Actor:        "mirror",
+		Action:       "mirror.changed",
+		Script:       script.Name,
+		BeforeSHA256: before,
+		AfterSHA256:  after,
+		Details:      details,
+	})
+}
+
+// mirrorInterval reads MIRROR_INTERVAL, defaulting to fifteen minutes
+func mirrorInterval() time.Duration {
+	if interval, err := time.ParseDuration(os.Getenv("MIRROR_INTERVAL")); err == nil && interval > 0 {
+		return interval
+	}
+	return 15 * time.Minute
+}
+
+func findMirrorScript(name string) (ScriptConfig, bool) {
+	script, ok := findScript(name)
+	return script, ok && script.Type == "mirror"
+}
+
+// getMirrorAPI returns the cache status of a mirror script and, while an
+// upstream change is held, its diff against the served copy
+func getMirrorAPI(c *fiber.Ctx) error {
+	script, ok := findMirrorScript(c.Params("name"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Script not found or not a mirror"})
+	}
+	status, ok := mirrorCache.Status(script.Name)
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Mirror has not been fetched yet"})
+	}
+
+	response := fiber.Map{"status": status}
+	if status.PendingSHA256 != "" {
+		served, _ := os.ReadFile(mirrorCache.contentFile(script.Name))
+		pending, _ := os.ReadFile(mirrorCache.pendingFile(script.Name))
+		response["diff"] = unifiedDiff(script.Name+" (served)", script.Name+" (upstream)", string(served), string(pending))
+	}
+	return c.JSON(response)
+}
+
+// refreshMirrorAPI revalidates a mirror now
+func refreshMirrorAPI(c *fiber.Ctx) error {
+	script, ok := findMirrorScript(c.Params("name"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Script not found or not a mirror"})
+	}
+
+	changed, err := mirrorCache.Refresh(script)
+	if err != nil {
+		return c.Status(502).JSON(fiber.Map{"error": "Failed to refresh mirror: " + err.Error()})
+	}
+	if changed {
+		updateIndexPageWithCurrentScripts()
+	}
+	status, _ := mirrorCache.Status(script.Name)
+	return c.JSON(status)
+}
+
+// acceptMirrorAPI serves a held upstream change
+func acceptMirrorAPI(c *fiber.Ctx) error {
+	script, ok := findMirrorScript(c.Params("name"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Script not found or not a mirror"})
+	}
+
+	var body struct {
+		SHA256 string `json:"sha256"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	status, previousHash, err := mirrorCache.Accept(script.Name, body.SHA256)
+	if err == errNoPendingChange {
+		return c.Status(404).JSON(fiber.Map{"error": "No upstream change is waiting"})
+	}
+	if err == errMirrorChanged {
+		return c.Status(409).JSON(fiber.Map{"error": "Upstream changed again since it was reviewed. Review the new version and try again."})
+	}
+	if err != nil {
+		log.Printf("Failed to accept upstream change of %s: %v", script.Name, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to accept upstream change"})
+	}
+
+	recordAudit(c, AuditEntry{
+		Action:       "mirror.accept",
+		Script:       script.Name,
+		BeforeSHA256: previousHash,
+		AfterSHA256:  status.SHA256,
+	})
+	updateIndexPageWithCurrentScripts()
+	return c.JSON(status)
+}
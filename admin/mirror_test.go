@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPublicIP(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":     true,
+		"2606:2800:220:1::": true,
+		"127.0.0.1":         false,
+		"10.1.2.3":          false,
+		"172.16.0.1":        false,
+		"192.168.1.1":       false,
+		"169.254.169.254":   false,
+		"100.64.0.1":        false,
+		"0.0.0.0":           false,
+		"::1":               false,
+		"fe80::1":           false,
+		"fd00::1":           false,
+		"::ffff:127.0.0.1":  false,
+	} {
+		if got := publicIP(net.ParseIP(addr)); got != want {
+			t.Errorf("publicIP(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestMirrorFetchRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("#!/bin/sh\n"))
+	}))
+	defer server.Close()
+
+	cache := &MirrorCache{client: newMirrorClient(false)}
+	if _, _, _, err := cache.fetch(server.URL, MirrorStatus{}, false); err == nil || !strings.Contains(err.Error(), "not a public address") {
+		t.Errorf("fetch from loopback = %v, want it refused", err)
+	}
+
+	cache.client = newMirrorClient(true)
+	if content, _, _, err := cache.fetch(server.URL, MirrorStatus{}, false); err != nil || string(content) != "#!/bin/sh\n" {
+		t.Errorf("fetch with private addresses allowed = %q, %v", content, err)
+	}
+}
+
+// fakeUpstream serves one script with an ETag and answers conditional
+// requests for it
+type fakeUpstream struct {
+	mu          sync.Mutex
+	content     string
+	etag        string
+	requests    int
+	ifNoneMatch string
+}
+
+func (u *fakeUpstream) set(content, etag string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.content, u.etag = content, etag
+}
+
+func (u *fakeUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.requests++
+	u.ifNoneMatch = r.Header.Get("If-None-Match")
+	if u.ifNoneMatch == u.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", u.etag)
+	w.Write([]byte(u.content))
+}
+
+// newMirrorTestServer serves a mirror script named tool from upstream, with
+// signing enabled
+func newMirrorTestServer(t *testing.T, upstream *fakeUpstream, pin bool) *testServer {
+	t.Helper()
+	server := httptest.NewServer(upstream)
+	t.Cleanup(server.Close)
+
+	s := newTestServer(t, fmt.Sprintf("scripts:\n  - name: tool\n    type: mirror\n    redirect_url: %s/tool.sh\n    pin_upstream: %t\n", server.URL, pin))
+	mirrorCache.client = newMirrorClient(true)
+	if err := initSigning(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// assertMirrorServes checks the stored copy of tool and that its signature
+// verifies
+func assertMirrorServes(t *testing.T, want string) {
+	t.Helper()
+	content, err := os.ReadFile(mirrorCache.contentFile("tool"))
+	if err != nil || string(content) != want {
+		t.Fatalf("stored copy = %q, %v; want %q", content, err, want)
+	}
+	signature, err := os.ReadFile(signatureFile("tool"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID, key := parseMinisignPublicKey(t, minisignPublicKey())
+	if _, err := verifyMinisign(keyID, key, string(signature), content); err != nil {
+		t.Errorf("signature of the stored copy: %v", err)
+	}
+}
+
+func TestMirrorRefreshIsConditional(t *testing.T) {
+	upstream := &fakeUpstream{content: "#!/bin/sh\necho v1\n", etag: `"v1"`}
+	newMirrorTestServer(t, upstream, false)
+	script, _ := findScript("tool")
+
+	if changed, err := mirrorCache.Refresh(script); err != nil || !changed {
+		t.Fatalf("first refresh = %t, %v", changed, err)
+	}
+	assertMirrorServes(t, "#!/bin/sh\necho v1\n")
+	status, _ := mirrorCache.Status("tool")
+	if status.ETag != `"v1"` || status.SHA256 != contentHash([]byte("#!/bin/sh\necho v1\n")) {
+		t.Errorf("status = %+v", status)
+	}
+
+	// An unchanged upstream answers 304 and the copy is kept
+	if changed, err := mirrorCache.Refresh(script); err != nil || changed {
+		t.Fatalf("refresh of an unchanged upstream = %t, %v", changed, err)
+	}
+	if upstream.ifNoneMatch != `"v1"` {
+		t.Errorf("If-None-Match = %q, want the stored ETag", upstream.ifNoneMatch)
+	}
+	assertMirrorServes(t, "#!/bin/sh\necho v1\n")
+
+	// Without pin_upstream a change is served at once and reported
+	upstream.set("#!/bin/sh\necho v2\n", `"v2"`)
+	if changed, err := mirrorCache.Refresh(script); err != nil || !changed {
+		t.Fatalf("refresh of a changed upstream = %t, %v", changed, err)
+	}
+	assertMirrorServes(t, "#!/bin/sh\necho v2\n")
+	entries, err := readAudit(auditFilter{Action: "mirror.changed"}, 10)
+	if err != nil || len(entries) != 1 || entries[0].AfterSHA256 != contentHash([]byte("#!/bin/sh\necho v2\n")) {
+		t.Errorf("mirror.changed audit = %+v, %v", entries, err)
+	}
+
+	// A failing upstream keeps the copy in service
+	if _, err := mirrorCache.Refresh(ScriptConfig{Name: "tool", Type: "mirror", RedirectURL: "http://127.0.0.1:1/tool.sh"}); err == nil {
+		t.Error("refresh of an unreachable upstream succeeded")
+	}
+	assertMirrorServes(t, "#!/bin/sh\necho v2\n")
+}
+
+func TestMirrorPinnedUpstreamChange(t *testing.T) {
+	upstream := &fakeUpstream{content: "#!/bin/sh\necho v1\n", etag: `"v1"`}
+	s := newMirrorTestServer(t, upstream, true)
+	editor := s.token("editor", scopeFull)
+
+	if status, body := s.request("POST", "/admin/scripts/tool/mirror/refresh", editor, nil); status != 200 {
+		t.Fatalf("refresh = %d %s", status, body)
+	}
+	assertMirrorServes(t, "#!/bin/sh\necho v1\n")
+
+	// The changed upstream is held, not served
+	v2 := "#!/bin/sh\necho v2\n"
+	upstream.set(v2, `"v2"`)
+	if status, body := s.request("POST", "/admin/scripts/tool/mirror/refresh", editor, nil); status != 200 {
+		t.Fatalf("refresh = %d %s", status, body)
+	}
+	assertMirrorServes(t, "#!/bin/sh\necho v1\n")
+
+	status, body := s.request("GET", "/admin/scripts/tool/mirror", editor, nil)
+	var mirror struct {
+		Status MirrorStatus `json:"status"`
+		Diff   string       `json:"diff"`
+	}
+	decodeJSON(t, body, &mirror)
+	if status != 200 || mirror.Status.PendingSHA256 != contentHash([]byte(v2)) || !strings.Contains(mirror.Diff, "-echo v1\n+echo v2\n") {
+		t.Fatalf("mirror = %d %s", status, body)
+	}
+
+	accept := "/admin/scripts/tool/mirror/accept"
+	if status, body := s.request("POST", accept, editor, map[string]string{"sha256": contentHash([]byte("other"))}); status != 409 {
+		t.Errorf("accept of another version = %d %s, want 409", status, body)
+	}
+	assertMirrorServes(t, "#!/bin/sh\necho v1\n")
+
+	if status, body := s.request("POST", accept, editor, map[string]string{"sha256": mirror.Status.PendingSHA256}); status != 200 {
+		t.Fatalf("accept = %d %s", status, body)
+	}
+	assertMirrorServes(t, v2)
+	if status, _ := s.request("POST", accept, editor, nil); status != 404 {
+		t.Errorf("accept with nothing held = %d, want 404", status)
+	}
+	entries, err := readAudit(auditFilter{Action: "mirror.accept"}, 10)
+	if err != nil || len(entries) != 1 || entries[0].Actor != "editor" || entries[0].AfterSHA256 != contentHash([]byte(v2)) {
+		t.Errorf("mirror.accept audit = %+v, %v", entries, err)
+	}
+}
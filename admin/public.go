@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// findScript looks up a script by name, accepting an optional ".sh" suffix
+func findScript(name string) (ScriptConfig, bool) {
+	for _, script := range configStore.Scripts() {
+		if script.Name == name {
+			return script, true
+		}
+	}
+	if trimmed := strings.TrimSuffix(name, ".sh"); trimmed != name {
+		return findScript(trimmed)
+	}
+	return ScriptConfig{}, false
+}
+
+// resolveScriptPath follows symlinks in path and reports whether the file it
+// names lies inside SCRIPTS_PATH. Nothing outside it may be published.
+func resolveScriptPath(path string) (string, bool) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", false
+	}
+	root, err := filepath.EvalSymlinks(scriptsPath)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return resolved, true
+}
+
+// localScriptFile returns the file backing a local script, trying the layouts
+// the server has used over time in order of preference. Candidates resolving
+// outside SCRIPTS_PATH are skipped.
+func localScriptFile(script ScriptConfig) string {
+	candidates := []string{
+		script.ScriptPath,
+		filepath.Join(scriptsPath, script.Name),
+		filepath.Join(scriptsPath, script.Name+"_dir", script.Name+".sh"),
+		filepath.Join(scriptsPath, script.Name, fmt.Sprintf("runme_%s.sh", script.Name)),
+		filepath.Join(scriptsPath, script.Name+".sh"),
+	}
+
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+		if _, ok := resolveScriptPath(candidate); !ok {
+			continue
+		}
+		if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+			return candidate
+		}
+	}
+
+	return filepath.Join(scriptsPath, script.Name, fmt.Sprintf("runme_%s.sh", script.Name))
+}
+
+// servedScriptFile returns the file delivered for a script: the local file or
+// the cached copy of a mirror. Redirect scripts have none.
+func servedScriptFile(script ScriptConfig) (string, bool) {
+	switch script.Type {
+	case "", "local":
+		return localScriptFile(script), true
+	case "mirror":
+		return mirrorCache.contentFile(script.Name), true
+	}
+	return "", false
+}
+
+func healthHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, "text/plain")
+	return c.SendString("OK")
+}
+
+func publicIndexHandler(c *fiber.Ctx) error {
+	content, err := os.ReadFile(filepath.Join(scriptsPath, "index.html"))
+	if err != nil {
+		return c.Status(404).SendString("Script not found")
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	return c.Send(content)
+}
+
+// publicScriptHandler delivers a script to consumers: local scripts and the
+// cached copies of mirrors are served from disk with conditional GET support,
+// redirect scripts are sent to their upstream URL.
+func publicScriptHandler(c *fiber.Ctx) error {
+	script, ok := findScript(c.Params("name"))
+	if !ok {
+		c.Set(fiber.HeaderContentType, "text/plain")
+		return c.Status(404).SendString("Script not found")
+	}
+
+	if script.Type == "redirect" {
+		if script.RedirectURL == "" {
+			return c.Status(404).SendString("Script not found")
+		}
+		countDownload(c, script)
+		return c.Redirect(script.RedirectURL, 302)
+	}
+
+	scriptFile, _ := servedScriptFile(script)
+	info, err := os.Stat(scriptFile)
+	if err != nil {
+		c.Set(fiber.HeaderContentType, "text/plain")
+		if script.Type == "mirror" {
+			return c.Status(503).SendString("Script is not available yet")
+		}
+		return c.Status(404).SendString("Script not found")
+	}
+	content, err := os.ReadFile(scriptFile)
+	if err != nil {
+		return c.Status(500).SendString("Failed to read script")
+	}
+	if script.Template {
+		if content, err = renderRequest(c, script, content); err != nil {
+			return err
+		}
+		c.Vary(fiber.HeaderHost)
+	}
+
+	etag := `"` + contentHash(content) + `"`
+	modTime := info.ModTime().UTC().Truncate(time.Second)
+
+	c.Set(fiber.HeaderContentType, "text/plain; charset=utf-8")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderLastModified, modTime.Format(http.TimeFormat))
+
+	if notModified(c, etag, modTime) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	countDownload(c, script)
+	return c.Send(content)
+}
+
+// countDownload records a delivery in the download stats. HEAD requests and
+// 304 revalidations are not downloads.
+func countDownload(c *fiber.Ctx, script ScriptConfig) {
+	if c.Method() != fiber.MethodGet {
+		return
+	}
+	statsStore.Record(script.Name, c.IP(), c.Get(fiber.HeaderUserAgent))
+	scriptDownloadsTotal.WithLabelValues(script.Name).Inc()
+}
+
+// scriptChecksum returns the SHA-256 of the content a script serves
+func scriptChecksum(script ScriptConfig) (string, bool) {
+	path, ok := servedScriptFile(script)
+	if !ok {
+		return "", false
+	}
+	hash := fileHash(path)
+	return hash, hash != ""
+}
+
+// scriptChecksumHandler serves a script's hash in sha256sum format, so that
+// `sha256sum -c` can check a download saved under the script's name. For
+// templates it is the hash of the variant selected by the same query.
+func scriptChecksumHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, "text/plain; charset=utf-8")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+
+	script, ok := findScript(c.Params("name"))
+	if !ok {
+		return c.Status(404).SendString("Script not found")
+	}
+	if script.Template {
+		content, err := os.ReadFile(localScriptFile(script))
+		if err != nil {
+			return c.Status(404).SendString("Checksum not available")
+		}
+		if content, err = renderRequest(c, script, content); err != nil {
+			return err
+		}
+		c.Vary(fiber.HeaderHost)
+		return c.SendString(contentHash(content) + "  " + script.Name + "\n")
+	}
+	hash, ok := scriptChecksum(script)
+	if !ok {
+		return c.Status(404).SendString("Checksum not available")
+	}
+	return c.SendString(hash + "  " + script.Name + "\n")
+}
+
+// checksumManifestHandler serves SHA256SUMS for all scripts served locally.
+// Templates are left out; each of their variants has its own checksum.
+func checksumManifestHandler(c *fiber.Ctx) error {
+	var manifest strings.Builder
+	for _, script := range configStore.Scripts() {
+		if script.Template {
+			continue
+		}
+		if hash, ok := scriptChecksum(script); ok {
+			manifest.WriteString(hash + "  " + script.Name + "\n")
+		}
+	}
+
+	c.Set(fiber.HeaderContentType, "text/plain; charset=utf-8")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	return c.SendString(manifest.String())
+}
+
+// notModified evaluates If-None-Match, falling back to If-Modified-Since
+func notModified(c *fiber.Ctx, etag string, modTime time.Time) bool {
+	if match := c.Get(fiber.HeaderIfNoneMatch); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if since := c.Get(fiber.HeaderIfModifiedSince); since != "" {
+		if t, err := http.ParseTime(since); err == nil && !modTime.After(t) {
+			return true
+		}
+	}
+
+	return false
+}
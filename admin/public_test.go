@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublicScriptConditionalGet(t *testing.T) {
+	s := newTestServer(t, "scripts:\n  - name: hello\n    type: local\n")
+	content := "#!/bin/bash\nset -e\necho hello\n"
+	s.writeScript("hello", content)
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(scriptsPath, "hello.sh"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(headers map[string]string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("GET", "/hello", nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := s.app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := get(nil)
+	etag := `"` + contentHash([]byte(content)) + `"`
+	lastModified := modTime.Format(http.TimeFormat)
+	if resp.StatusCode != 200 || resp.Header.Get("ETag") != etag || resp.Header.Get("Last-Modified") != lastModified {
+		t.Fatalf("GET = %d, ETag %q, Last-Modified %q", resp.StatusCode, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"))
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"matching ETag", map[string]string{"If-None-Match": etag}, 304},
+		{"weak ETag in a list", map[string]string{"If-None-Match": `"other", W/` + etag}, 304},
+		{"any ETag", map[string]string{"If-None-Match": "*"}, 304},
+		{"other ETag", map[string]string{"If-None-Match": `"other"`}, 200},
+		{"not modified since", map[string]string{"If-Modified-Since": lastModified}, 304},
+		{"modified since", map[string]string{"If-Modified-Since": modTime.Add(-time.Hour).Format(http.TimeFormat)}, 200},
+		// If-None-Match takes precedence over If-Modified-Since
+		{"other ETag, not modified since", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": lastModified}, 200},
+	}
+	for _, tt := range tests {
+		if resp := get(tt.headers); resp.StatusCode != tt.want {
+			t.Errorf("%s: GET = %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestResolveScriptPath(t *testing.T) {
+	newTestServer(t, "")
+	outside := filepath.Join(filepath.Dir(scriptsPath), "secret.sh")
+	writeTestFile(t, outside, "secret\n")
+	inside := filepath.Join(scriptsPath, "hello", "hello.sh")
+	writeTestFile(t, inside, "#!/bin/sh\n")
+	if err := os.Symlink(inside, filepath.Join(scriptsPath, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(scriptsPath, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{inside, true},
+		{filepath.Join(scriptsPath, "link"), true},
+		{filepath.Join(scriptsPath, "escape"), false},
+		{outside, false},
+		{scriptsPath + "/../secret.sh", false},
+		{scriptsPath + "/hello/../../secret.sh", false},
+		{scriptsPath, false},
+		{filepath.Join(scriptsPath, "missing.sh"), false},
+	}
+	for _, tt := range tests {
+		resolved, ok := resolveScriptPath(tt.path)
+		if ok != tt.want {
+			t.Errorf("resolveScriptPath(%s) = %q, %t; want %t", tt.path, resolved, ok, tt.want)
+		}
+	}
+}
+
+func TestPublicScriptStaysInScriptsPath(t *testing.T) {
+	s := newTestServer(t, "")
+	outside := filepath.Join(s.dir, "secret.sh")
+	writeTestFile(t, outside, "secret\n")
+	if err := configStore.Update(func(cfg *Config) error {
+		cfg.Scripts = append(cfg.Scripts,
+			ScriptConfig{Name: "evil", Type: "local", ScriptPath: scriptsPath + "/../secret.sh"},
+			ScriptConfig{Name: "linked", Type: "local", ScriptPath: filepath.Join(scriptsPath, "linked.sh")})
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(scriptsPath, "linked.sh")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/evil", "/linked", "/evil.sha256", "/..%2Fsecret.sh", "/%2E%2E%2Fconfig.yaml"} {
+		status, body := s.request("GET", path, "", nil)
+		if status == 200 || strings.Contains(string(body), "secret") || strings.Contains(string(body), "password_hash") {
+			t.Errorf("GET %s = %d %q, want it refused", path, status, body)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Revision describes one immutable snapshot of a local script's content
+type Revision struct {
+	ID         string    `json:"id"`
+	Script     string    `json:"script"`
+	Timestamp  time.Time `json:"timestamp"`
+	Author     string    `json:"author"`
+	Size       int64     `json:"size"`
+	SHA256     string    `json:"sha256"`
+	RollbackOf string    `json:"rollback_of,omitempty"`
+	// Commit is the git commit content synced from a git source came from
+	Commit string `json:"commit,omitempty"`
+}
+
+// revisionsDir returns the directory holding all revisions of a script
+func revisionsDir(scriptName string) string {
+	return filepath.Join(dataPath, "revisions", scriptName)
+}
+
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// saveRevision stores content as a new revision of the script. Revision files
+// are created exclusively and never rewritten.
+func saveRevision(scriptName string, content []byte, author, rollbackOf, commit string) (*Revision, error) {
+	dir := revisionsDir(scriptName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	rev := &Revision{
+		ID:         newRevisionID(now),
+		Script:     scriptName,
+		Timestamp:  now,
+		Author:     author,
+		Size:       int64(len(content)),
+		SHA256:     contentHash(content),
+		RollbackOf: rollbackOf,
+		Commit:     commit,
+	}
+
+	if err := writeFileExclusive(filepath.Join(dir, rev.ID+".sh"), content, 0644); err != nil {
+		return nil, err
+	}
+
+	meta, err := json.MarshalIndent(rev, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileExclusive(filepath.Join(dir, rev.ID+".json"), meta, 0644); err != nil {
+		return nil, err
+	}
+
+	return rev, nil
+}
+
+// newRevisionID returns an ID that sorts by time. The random suffix keeps two
+// saves in the same nanosecond from colliding.
+func newRevisionID(t time.Time) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return t.Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix)
+}
+
+func writeFileExclusive(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// listRevisions returns all revisions of a script, newest first
+func listRevisions(scriptName string) ([]Revision, error) {
+	entries, err := os.ReadDir(revisionsDir(scriptName))
+	if os.IsNotExist(err) {
+		return []Revision{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := []Revision{}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		rev, err := loadRevision(scriptName, strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			log.Printf("Skipping unreadable revision %s/%s: %v", scriptName, entry.Name(), err)
+			continue
+		}
+		revisions = append(revisions, *rev)
+	}
+
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].ID > revisions[j].ID
+	})
+	return revisions, nil
+}
+
+func loadRevision(scriptName, id string) (*Revision, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.Contains(id, "..") {
+		return nil, os.ErrNotExist
+	}
+
+	data, err := os.ReadFile(filepath.Join(revisionsDir(scriptName), id+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var rev Revision
+	if err := json.Unmarshal(data, &rev); err != nil {
+		return nil, err
+	}
+	return &rev, nil
+}
+
+func loadRevisionContent(scriptName, id string) ([]byte, error) {
+	if _, err := loadRevision(scriptName, id); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filepath.Join(revisionsDir(scriptName), id+".sh"))
+}
+
+// snapshotIfUntracked records the current live content as a baseline revision
+// when a script has no history yet, so the first edit never loses it.
+func snapshotIfUntracked(script ScriptConfig) {
+	revisions, err := listRevisions(script.Name)
+	if err != nil || len(revisions) > 0 {
+		return
+	}
+
+	content, err := os.ReadFile(localScriptFile(script))
+	if err != nil {
+		return
+	}
+
+	if _, err := saveRevision(script.Name, content, "system", "", ""); err != nil {
+		log.Printf("Failed to snapshot baseline revision for %s: %v", script.Name, err)
+	}
+}
+
+// contentMu serializes writes to script content and revision history
+var contentMu sync.Mutex
+
+// writeScriptContent records content as a revision of a local script and
+// then makes it live. It also returns the SHA-256 of the content it replaced.
+// The revision comes first so that nothing is served without one, and the
+// live file is replaced atomically so a crash cannot leave it truncated.
+func writeScriptContent(script ScriptConfig, content []byte, author, rollbackOf, commit string) (*Revision, string, error) {
+	contentMu.Lock()
+	defer contentMu.Unlock()
+
+	snapshotIfUntracked(script)
+
+	path := localScriptFile(script)
+	previousHash := fileHash(path)
+	rev, err := saveRevision(script.Name, content, author, rollbackOf, commit)
+	if err != nil {
+		return nil, previousHash, err
+	}
+
+	// Replace the file a symlinked script points to, not the link
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	if err := writeFileAtomic(path, content, 0755); err != nil {
+		return nil, previousHash, err
+	}
+	if err := signScript(script.Name, content); err != nil {
+		log.Printf("Failed to sign %s: %v", script.Name, err)
+	}
+	return rev, previousHash, nil
+}
+
+// currentUser returns the username authenticated by authMiddleware
+func currentUser(c *fiber.Ctx) string {
+	if user, ok := c.Locals("user").(User); ok {
+		return user.Username
+	}
+	return ""
+}
+
+func findLocalScript(name string) (ScriptConfig, bool) {
+	for _, script := range configStore.Scripts() {
+		if script.Name == name && script.Type == "local" {
+			return script, true
+		}
+	}
+	return ScriptConfig{}, false
+}
+
+func listRevisionsAPI(c *fiber.Ctx) error {
+	script, ok := findLocalScript(c.Params("name"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Script not found or not local"})
+	}
+
+	revisions, err := listRevisions(script.Name)
+	if err != nil {
+		log.Printf("Failed to list revisions for %s: %v", script.Name, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to list revisions"})
+	}
+
+	return c.JSON(revisions)
+}
+
+func getRevisionAPI(c *fiber.Ctx) error {
+	script, ok := findLocalScript(c.Params("name"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Script not found or not local"})
+	}
+
+	rev, err := loadRevision(script.Name, c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Revision not found"})
+	}
+	content, err := loadRevisionContent(script.Name, rev.ID)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Revision content not found"})
+	}
+
+	return c.JSON(fiber.Map{
+		"revision": rev,
+		"content":  string(content),
+	})
+}
+
+func rollbackRevisionAPI(c *fiber.Ctx) error {
+	script, ok := findLocalScript(c.Params("name"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Script not found or not local"})
+	}
+
+	if script.Git != nil {
+		return gitManagedError(c, script)
+	}
+
+	id := c.Params("id")
+	content, err := loadRevisionContent(script.Name, id)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Revision not found"})
+	}
+
+	if reviewEnabled {
+		draft, err := saveDraft(script, content, currentUser(c), id)
+		if err != nil {
+			return draftSaveError(c, script.Name, err)
+		}
+		recordAudit(c, AuditEntry{
+			Action:      "script.draft",
+			Script:      script.Name,
+			AfterSHA256: draft.SHA256,
+			Details:     "rollback to revision " + id,
+		})
+		return c.JSON(fiber.Map{
+			"message": "Rollback saved as a draft and waiting for review",
+			"draft":   draft,
+		})
+	}
+
+	rev, previousHash, err := writeScriptContent(script, content, currentUser(c), id, "")
+	if err != nil {
+		log.Printf("Failed to roll back %s to %s: %v", script.Name, id, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to roll back script"})
+	}
+
+	log.Printf("Rolled back %s to revision %s", script.Name, id)
+	recordAudit(c, AuditEntry{
+		Action:       "script.rollback",
+		Script:       script.Name,
+		BeforeSHA256: previousHash,
+		AfterSHA256:  contentHash(content),
+		Details:      "rolled back to revision " + id,
+	})
+	updateIndexPageWithCurrentScripts()
+	return c.JSON(fiber.Map{
+		"message":  "Script rolled back successfully",
+		"revision": rev,
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// liveContent reads the live content of the local script hello
+func liveContent(t *testing.T) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(scriptsPath, "hello.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestRevisionsSaveListRollback(t *testing.T) {
+	s := newTestServer(t, "scripts:\n  - name: hello\n    type: local\n")
+	v0, v1 := "#!/bin/bash\nset -e\necho v0\n", "#!/bin/bash\nset -e\necho v1\n"
+	s.writeScript("hello", v0)
+	editor := s.token("editor", scopeFull)
+
+	if status, body := s.request("PUT", "/admin/scripts/hello/content", editor, map[string]string{"content": v1}); status != 200 {
+		t.Fatalf("save = %d %s", status, body)
+	}
+
+	// The content from before the first save is kept as a baseline
+	status, body := s.request("GET", "/admin/scripts/hello/revisions", editor, nil)
+	var revisions []Revision
+	decodeJSON(t, body, &revisions)
+	if status != 200 || len(revisions) != 2 {
+		t.Fatalf("revisions = %d %s", status, body)
+	}
+	saved, baseline := revisions[0], revisions[1]
+	if saved.Author != "editor" || saved.SHA256 != contentHash([]byte(v1)) || baseline.Author != "system" || baseline.SHA256 != contentHash([]byte(v0)) {
+		t.Errorf("revisions = %+v, want the save by editor then the baseline", revisions)
+	}
+
+	status, body = s.request("GET", "/admin/scripts/hello/revisions/"+baseline.ID, editor, nil)
+	var revision struct {
+		Revision Revision `json:"revision"`
+		Content  string   `json:"content"`
+	}
+	decodeJSON(t, body, &revision)
+	if status != 200 || revision.Content != v0 {
+		t.Errorf("baseline revision = %d %s", status, body)
+	}
+	for _, id := range []string{"missing", "..", url.PathEscape("../hello/" + baseline.ID)} {
+		if status, _ := s.request("GET", "/admin/scripts/hello/revisions/"+id, editor, nil); status != 404 {
+			t.Errorf("revision %q = %d, want 404", id, status)
+		}
+	}
+
+	if status, body := s.request("POST", "/admin/scripts/hello/revisions/"+baseline.ID+"/rollback", editor, nil); status != 200 {
+		t.Fatalf("rollback = %d %s", status, body)
+	}
+	if got := liveContent(t); got != v0 {
+		t.Errorf("live content after rollback = %q, want %q", got, v0)
+	}
+	revisions, err := listRevisions("hello")
+	if err != nil || len(revisions) != 3 || revisions[0].RollbackOf != baseline.ID || revisions[0].SHA256 != baseline.SHA256 {
+		t.Errorf("revisions after rollback = %+v, %v", revisions, err)
+	}
+}
+
+func TestRollbackThroughDraft(t *testing.T) {
+	s := newTestServer(t, "scripts:\n  - name: hello\n    type: local\n")
+	v0, v1 := "#!/bin/bash\nset -e\necho v0\n", "#!/bin/bash\nset -e\necho v1\n"
+	s.writeScript("hello", v0)
+	editor := s.token("editor", scopeFull)
+	admin := s.token("admin", scopeFull)
+	if status, body := s.request("PUT", "/admin/scripts/hello/content", editor, map[string]string{"content": v1}); status != 200 {
+		t.Fatalf("save = %d %s", status, body)
+	}
+	revisions, err := listRevisions("hello")
+	if err != nil || len(revisions) != 2 {
+		t.Fatalf("revisions = %+v, %v", revisions, err)
+	}
+	baseline := revisions[1]
+
+	// With review enabled a rollback waits for approval like any other change
+	swapGlobal(t, &reviewEnabled, true)
+	status, body := s.request("POST", "/admin/scripts/hello/revisions/"+baseline.ID+"/rollback", editor, nil)
+	var saved struct{ Draft Draft }
+	decodeJSON(t, body, &saved)
+	if status != 200 || saved.Draft.RollbackOf != baseline.ID || saved.Draft.SHA256 != baseline.SHA256 {
+		t.Fatalf("rollback = %d %s, want a draft of the baseline", status, body)
+	}
+	if got := liveContent(t); got != v1 {
+		t.Errorf("live content before approval = %q, want %q", got, v1)
+	}
+
+	if status, body := s.request("POST", "/admin/scripts/hello/draft/approve", admin, map[string]string{"sha256": saved.Draft.SHA256}); status != 200 {
+		t.Fatalf("approve = %d %s", status, body)
+	}
+	if got := liveContent(t); got != v0 {
+		t.Errorf("live content after approval = %q, want %q", got, v0)
+	}
+	revisions, err = listRevisions("hello")
+	if err != nil || len(revisions) != 3 || revisions[0].Author != "editor" || revisions[0].RollbackOf != baseline.ID {
+		t.Errorf("revisions after approval = %+v, %v", revisions, err)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ScanFinding is a secret or dangerous command found in script content.
+// Secrets are redacted so the report itself does not leak them.
+type ScanFinding struct {
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Rule     string `json:"rule"`
+	Category string `json:"category"` // "secret" or "danger"
+	Message  string `json:"message"`
+	Match    string `json:"match"`
+}
+
+type scanRule struct {
+	name     string
+	category string
+	message  string
+	pattern  *regexp.Regexp
+}
+
+var scanRules = []scanRule{
+	{"aws-access-key", "secret", "AWS access key ID",
+		regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"aws-secret-key", "secret", "AWS secret access key",
+		regexp.MustCompile(`(?i)aws_secret_access_key\s*[=:]\s*["']?[A-Za-z0-9/+=]{40}`)},
+	{"github-token", "secret", "GitHub token",
+		regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{"slack-token", "secret", "Slack token",
+		regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}\b`)},
+	{"api-token", "secret", "Script server API token",
+		regexp.MustCompile(`\b` + tokenPrefix + `[0-9a-f]{64}\b`)},
+	{"private-key", "secret", "Private key block",
+		regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY-----`)},
+
+	{"rm-root", "danger", "rm on the root directory",
+		regexp.MustCompile(`\brm\s+(?:-{1,2}[\w-]+\s+)*["']?/\*?["']?(?:\s|;|&|\||$)`)},
+	{"fork-bomb", "danger", "Fork bomb",
+		regexp.MustCompile(`:\s*\(\s*\)\s*\{\s*:\s*\|\s*:\s*&\s*\}\s*;\s*:`)},
+	{"chmod-root", "danger", "World-writable permissions on the root directory",
+		regexp.MustCompile(`\bchmod\s+(?:-[a-zA-Z]+\s+)*(?:0?777|a\+rwx|ugo\+rwx)\s+["']?/["']?(?:\s|;|&|\||$)`)},
+	{"disk-overwrite", "danger", "Writes directly to a disk device",
+		regexp.MustCompile(`\b(?:dd\s+[^\n]*of=|mkfs(?:\.\w+)?\s+[^\n]*)/dev/(?:sd[a-z]|nvme\d|hd[a-z]|vd[a-z]|xvd[a-z])`)},
+}
+
+// highEntropyToken matches candidates for the entropy check: long runs of
+// base64 characters, as found in API keys and passwords. '-' and '_' are
+// left out so that dashed and snake_case names split into short words.
+var highEntropyToken = regexp.MustCompile(`[A-Za-z0-9+/]{32,}={0,2}`)
+
+// minSecretEntropy is in bits per character; random base62 strings of 32
+// characters average about 4.5
+const minSecretEntropy = 4.3
+
+var digitRun = regexp.MustCompile(`[0-9]+`)
+
+// scanContent looks for secrets and dangerous commands line by line. Lines
+// are split without a length limit, so a long line cannot end the scan early.
+func scanContent(content []byte) []ScanFinding {
+	findings := []ScanFinding{}
+
+	for i, line := range strings.Split(string(content), "\n") {
+		lineNo := i + 1
+		line = strings.TrimSuffix(line, "\r")
+		matched := false
+
+		for _, rule := range scanRules {
+			for _, loc := range rule.pattern.FindAllStringIndex(line, -1) {
+				findings = append(findings, newFinding(line, lineNo, loc, rule.name, rule.category, rule.message))
+				matched = true
+			}
+		}
+		if matched {
+			continue
+		}
+
+		for _, loc := range highEntropyToken.FindAllStringIndex(line, -1) {
+			token := line[loc[0]:loc[1]]
+			if !looksLikeSecret(token) {
+				continue
+			}
+			findings = append(findings, newFinding(line, lineNo, loc, "high-entropy", "secret", "High-entropy string that looks like a key or password"))
+		}
+	}
+	return findings
+}
+
+func newFinding(line string, lineNo int, loc []int, rule, category, message string) ScanFinding {
+	match := line[loc[0]:loc[1]]
+	if category == "secret" {
+		match = redactSecret(match)
+	}
+	return ScanFinding{
+		Line:     lineNo,
+		Column:   utf8.RuneCountInString(line[:loc[0]]) + 1,
+		Rule:     rule,
+		Category: category,
+		Message:  message,
+		Match:    match,
+	}
+}
+
+// redactSecret keeps just enough of a secret to recognise it
+func redactSecret(secret string) string {
+	if len(secret) <= 8 {
+		return strings.Repeat("*", len(secret))
+	}
+	return secret[:6] + strings.Repeat("*", 6) + secret[len(secret)-2:]
+}
+
+// looksLikeSecret filters entropy candidates. Random keys mix upper case,
+// lower case and digits, which rules out hex checksums, and have digits
+// scattered through them rather than in a single version-like suffix. Paths
+// and URLs are made of several slash-separated segments.
+func looksLikeSecret(token string) bool {
+	if strings.Count(token, "/") > 2 {
+		return false
+	}
+	if !strings.ContainsAny(token, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") ||
+		!strings.ContainsAny(token, "abcdefghijklmnopqrstuvwxyz") ||
+		len(digitRun.FindAllString(token, -1)) < 2 {
+		return false
+	}
+	return shannonEntropy(token) >= minSecretEntropy
+}
+
+func shannonEntropy(s string) float64 {
+	counts := map[rune]int{}
+	for _, r := range s {
+		counts[r]++
+	}
+	var entropy float64
+	n := float64(len(s))
+	for _, count := range counts {
+		p := float64(count) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+func findingRules(findings []ScanFinding) string {
+	seen := map[string]bool{}
+	var rules []string
+	for _, finding := range findings {
+		if !seen[finding.Rule] {
+			seen[finding.Rule] = true
+			rules = append(rules, finding.Rule)
+		}
+	}
+	return strings.Join(rules, ",")
+}
+
+// scanOverride reports whether the request asks to save despite findings
+// and comes from an admin
+func scanOverride(c *fiber.Ctx) bool {
+	user, _ := c.Locals("user").(User)
+	return c.Query("override") == "true" && user.Role == roleAdmin
+}
+
+// scanBlockedResponse reports findings that stop a save
+func scanBlockedResponse(c *fiber.Ctx, findings []ScanFinding) error {
+	user, _ := c.Locals("user").(User)
+	return c.Status(422).JSON(fiber.Map{
+		"error":            fmt.Sprintf("Content scan found %d possible secrets or dangerous commands", len(findings)),
+		"findings":         findings,
+		"override_allowed": user.Role == roleAdmin,
+	})
+}
+
+// auditScanOverride records that an admin saved content despite findings
+func auditScanOverride(c *fiber.Ctx, scriptName string, findings []ScanFinding) {
+	if len(findings) == 0 {
+		return
+	}
+	recordAudit(c, AuditEntry{
+		Action:  "script.scan_override",
+		Script:  scriptName,
+		Details: "saved despite findings: " + findingRules(findings),
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScanContentAfterLongLine(t *testing.T) {
+	content := "#!/bin/sh\n# " + strings.Repeat("a ", 600000) + "\r\nrm -rf /\n"
+
+	findings := scanContent([]byte(content))
+	if len(findings) != 1 || findings[0].Rule != "rm-root" || findings[0].Line != 3 {
+		t.Errorf("findings = %+v, want rm-root on line 3", findings)
+	}
+}
+
+func TestScanRules(t *testing.T) {
+	// Secrets are assembled from parts so this file does not trip scanners
+	tests := []struct {
+		rule, match, miss string
+	}{
+		{"aws-access-key", "export AWS_ACCESS_KEY_ID=" + "AKIA" + "IOSFODNN7EXAMPLE", "export AWS_ACCESS_KEY_ID=AKIA1234"},
+		{"aws-secret-key", "aws_secret_access_key = " + "wJalrXUtnFEMI/K7MDENG/" + "bPxRfiCYEXAMPLEKEY", "aws_secret_access_key=$AWS_SECRET_ACCESS_KEY"},
+		{"github-token", "TOKEN=" + "ghp_" + strings.Repeat("aB3", 12), "TOKEN=ghp_short"},
+		{"slack-token", "SLACK=" + "xoxb-" + "1234567890-abcdef", "SLACK=xoxb-123"},
+		{"api-token", "curl -H 'Authorization: Bearer " + tokenPrefix + strings.Repeat("0a", 32) + "'", "curl -H 'Authorization: Bearer $" + strings.ToUpper(tokenPrefix) + "TOKEN'"},
+		{"private-key", "-----BEGIN OPENSSH " + "PRIVATE KEY-----", "-----BEGIN PUBLIC KEY-----"},
+		{"rm-root", "rm -rf /", "rm -rf /tmp/build"},
+		{"rm-root", "sudo rm -rf --no-preserve-root /* && echo done", "rm -rf \"$PREFIX/\""},
+		{"fork-bomb", ":(){ :|:& };:", "cleanup() { kill $pid | true & }; cleanup"},
+		{"chmod-root", "chmod -R 777 /", "chmod 777 /tmp/shared"},
+		{"disk-overwrite", "dd if=image.iso of=/dev/sda bs=4M", "dd if=/dev/zero of=disk.img bs=1M count=10"},
+		{"disk-overwrite", "mkfs.ext4 /dev/nvme0n1", "mkfs.ext4 disk.img"},
+		{"high-entropy", "API_KEY=" + "q8Zr2LmX9vTk4WpB" + "7nYc3HdJ6sFg1QaE", "SHA256=9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"},
+		{"high-entropy", "PASSWORD=" + "Tr0ub4dor3xKp9Qz" + "Lm2Wv7Yb5Nc8Hj1F", "cp /usr/local/share/ca-certificates/Example/RootCertificateAuthority2024.crt /etc/ssl"},
+	}
+	for _, tt := range tests {
+		findings := scanContent([]byte("#!/bin/sh\n" + tt.match + "\n"))
+		if len(findings) != 1 || findings[0].Rule != tt.rule || findings[0].Line != 2 {
+			t.Errorf("scan %q = %+v, want one %s finding on line 2", tt.match, findings, tt.rule)
+		} else if findings[0].Category == "secret" && strings.Contains(tt.match, findings[0].Match) {
+			t.Errorf("%s finding shows the secret: %q", tt.rule, findings[0].Match)
+		}
+
+		if findings := scanContent([]byte("#!/bin/sh\n" + tt.miss + "\n")); len(findings) != 0 {
+			t.Errorf("scan %q = %+v, want no findings", tt.miss, findings)
+		}
+	}
+}
+
+func TestScanOverride(t *testing.T) {
+	s := newTestServer(t, "scripts:\n  - name: hello\n    type: local\n")
+	live := "#!/bin/bash\nset -e\necho hello\n"
+	s.writeScript("hello", live)
+	dangerous := map[string]string{"content": "#!/bin/bash\nset -e\nrm -rf /\n"}
+
+	tests := []struct {
+		user, query     string
+		overrideAllowed bool
+	}{
+		{"editor", "", false},
+		{"editor", "?override=true", false},
+		{"admin", "", true},
+	}
+	for _, tt := range tests {
+		status, body := s.request("PUT", "/admin/scripts/hello/content"+tt.query, s.token(tt.user, scopeFull), dangerous)
+		var blocked struct {
+			Findings        []ScanFinding `json:"findings"`
+			OverrideAllowed bool          `json:"override_allowed"`
+		}
+		decodeJSON(t, body, &blocked)
+		if status != 422 || len(blocked.Findings) != 1 || blocked.OverrideAllowed != tt.overrideAllowed {
+			t.Errorf("save by %s%s = %d %s, want 422 with override_allowed %t", tt.user, tt.query, status, body, tt.overrideAllowed)
+		}
+	}
+	if content, _ := os.ReadFile(filepath.Join(scriptsPath, "hello.sh")); string(content) != live {
+		t.Fatalf("blocked save changed the script to %q", content)
+	}
+
+	admin := s.token("admin", scopeFull)
+	if status, body := s.request("PUT", "/admin/scripts/hello/content?override=true", admin, dangerous); status != 200 {
+		t.Fatalf("save by admin with override = %d %s", status, body)
+	}
+	if content, _ := os.ReadFile(filepath.Join(scriptsPath, "hello.sh")); string(content) != dangerous["content"] {
+		t.Errorf("script = %q after the override", content)
+	}
+
+	status, body := s.request("GET", "/admin/audit?action=script.scan_override", admin, nil)
+	var entries []AuditEntry
+	decodeJSON(t, body, &entries)
+	if status != 200 || len(entries) != 1 || entries[0].Actor != "admin" || entries[0].Script != "hello" || !strings.Contains(entries[0].Details, "rm-root") {
+		t.Errorf("override audit = %d %+v", status, entries)
+	}
+}
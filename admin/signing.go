@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Scripts are signed in minisign format so users can verify them with
+// `minisign -V` before piping them into a shell. Signatures are made when
+// content is written through the admin server, so a file changed on disk by
+// other means no longer verifies.
+
+const publicKeyPath = "/.well-known/minisign.pub"
+
+var (
+	signingKey   ed25519.PrivateKey
+	signingKeyID [8]byte
+)
+
+// initSigning loads the Ed25519 signing key from DATA_PATH, creating one on
+// first start.
+func initSigning() error {
+	keyPath := filepath.Join(dataPath, "signing.key")
+
+	data, err := os.ReadFile(keyPath)
+	if os.IsNotExist(err) {
+		if err := generateSigningKey(keyPath); err != nil {
+			return err
+		}
+		data, err = os.ReadFile(keyPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return errors.New("signing key is not PEM encoded")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return errors.New("signing key is not an Ed25519 key")
+	}
+
+	signingKey = edKey
+	// minisign key IDs are arbitrary; derive ours from the public key so it
+	// does not need to be stored separately
+	sum := sha256.Sum256(edKey.Public().(ed25519.PublicKey))
+	copy(signingKeyID[:], sum[:8])
+	return nil
+}
+
+func generateSigningKey(keyPath string) error {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0755); err != nil {
+		return err
+	}
+	log.Printf("Generated new script signing key at %s", keyPath)
+	return writeFileExclusive(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+}
+
+func signatureFile(scriptName string) string {
+	return filepath.Join(dataPath, "signatures", scriptName+".minisig")
+}
+
+// minisignPublicKey returns the public key in minisign's file format
+func minisignPublicKey() string {
+	blob := append([]byte("Ed"), signingKeyID[:]...)
+	blob = append(blob, signingKey.Public().(ed25519.PublicKey)...)
+	return fmt.Sprintf("untrusted comment: minisign public key %016X\n%s\n",
+		binary.LittleEndian.Uint64(signingKeyID[:]), base64.StdEncoding.EncodeToString(blob))
+}
+
+// minisignSignature signs content as a prehashed ("ED") minisign signature
+func minisignSignature(fileName string, content []byte) string {
+	hash := blake2b.Sum512(content)
+	signature := ed25519.Sign(signingKey, hash[:])
+
+	blob := append([]byte("ED"), signingKeyID[:]...)
+	blob = append(blob, signature...)
+
+	trustedComment := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", time.Now().Unix(), fileName)
+	globalSignature := ed25519.Sign(signingKey, append(append([]byte{}, signature...), trustedComment...))
+
+	return fmt.Sprintf("untrusted comment: signature from script server\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(blob), trustedComment,
+		base64.StdEncoding.EncodeToString(globalSignature))
+}
+
+// signScript stores a detached signature for a script's content
+func signScript(scriptName string, content []byte) error {
+	if signingKey == nil {
+		return nil
+	}
+	path := signatureFile(scriptName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, []byte(minisignSignature(scriptName, content)), 0644)
+}
+
+func removeSignature(scriptName string) {
+	os.Remove(signatureFile(scriptName))
+}
+
+// signUnsignedScripts signs local scripts that predate signing. Scripts that
+// already have a signature are left alone so on-disk tampering stays visible.
+func signUnsignedScripts() {
+	for _, script := range configStore.Scripts() {
+		if script.Type != "local" {
+			continue
+		}
+		if _, err := os.Stat(signatureFile(script.Name)); err == nil {
+			continue
+		}
+		content, err := os.ReadFile(localScriptFile(script))
+		if err != nil {
+			continue
+		}
+		if err := signScript(script.Name, content); err != nil {
+			log.Printf("Failed to sign %s: %v", script.Name, err)
+			continue
+		}
+		log.Printf("Signed existing script %s", script.Name)
+	}
+}
+
+func publicKeyHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, "text/plain; charset=utf-8")
+	if signingKey == nil {
+		return c.Status(404).SendString("Script signing is not enabled")
+	}
+	return c.SendString(minisignPublicKey())
+}
+
+func scriptSignatureHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, "text/plain; charset=utf-8")
+
+	script, ok := findScript(c.Params("name"))
+	if _, served := servedScriptFile(script); !ok || !served {
+		return c.Status(404).SendString("Signature not found")
+	}
+	// The stored signature covers the template, not what is served
+	if script.Template {
+		return c.Status(404).SendString("Template scripts are not signed; use the .sha256 of the variant")
+	}
+	signature, err := os.ReadFile(signatureFile(script.Name))
+	if err != nil {
+		return c.Status(404).SendString("Signature not found")
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	return c.Send(signature)
+}
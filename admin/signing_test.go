@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// parseMinisignPublicKey parses a public key file as minisign does and
+// returns its key ID and key
+func parseMinisignPublicKey(t *testing.T, file string) ([]byte, ed25519.PublicKey) {
+	t.Helper()
+	lines := strings.Split(strings.TrimSuffix(file, "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "untrusted comment: ") {
+		t.Fatalf("public key file = %q", file)
+	}
+	blob, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(blob) != 2+8+ed25519.PublicKeySize || string(blob[:2]) != "Ed" {
+		t.Fatalf("public key = %q, %v", lines[1], err)
+	}
+	keyID := blob[2:10]
+	if want := fmt.Sprintf("minisign public key %016X", binary.LittleEndian.Uint64(keyID)); !strings.HasSuffix(lines[0], want) {
+		t.Errorf("comment = %q, want it to end with %q", lines[0], want)
+	}
+	return keyID, ed25519.PublicKey(blob[10:])
+}
+
+// verifyMinisign verifies a prehashed signature file against content the
+// way `minisign -V` does, and returns its trusted comment
+func verifyMinisign(keyID []byte, key ed25519.PublicKey, file string, content []byte) (string, error) {
+	lines := strings.Split(strings.TrimSuffix(file, "\n"), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "untrusted comment: ") || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return "", fmt.Errorf("malformed signature file %q", file)
+	}
+	blob, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(blob) != 2+8+ed25519.SignatureSize {
+		return "", fmt.Errorf("malformed signature %q", lines[1])
+	}
+	if string(blob[:2]) != "ED" {
+		return "", fmt.Errorf("algorithm %q, want the prehashed ED", blob[:2])
+	}
+	if !bytes.Equal(blob[2:10], keyID) {
+		return "", fmt.Errorf("key ID %X, want %X", blob[2:10], keyID)
+	}
+	signature := blob[10:]
+	hash := blake2b.Sum512(content)
+	if !ed25519.Verify(key, hash[:], signature) {
+		return "", fmt.Errorf("signature does not match the content")
+	}
+
+	trustedComment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	globalSignature, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil {
+		return "", fmt.Errorf("malformed global signature %q", lines[3])
+	}
+	if !ed25519.Verify(key, append(append([]byte{}, signature...), trustedComment...), globalSignature) {
+		return "", fmt.Errorf("global signature does not match the trusted comment")
+	}
+	return trustedComment, nil
+}
+
+func TestMinisignRoundTrip(t *testing.T) {
+	s := newTestServer(t, "scripts:\n  - name: hello\n    type: local\n")
+	if err := initSigning(); err != nil {
+		t.Fatal(err)
+	}
+	content := "#!/bin/bash\nset -e\necho hello\n"
+	s.writeScript("hello", content)
+	if err := signScript("hello", []byte(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	status, body := s.request("GET", publicKeyPath, "", nil)
+	if status != http.StatusOK {
+		t.Fatalf("public key = %d %s", status, body)
+	}
+	keyID, key := parseMinisignPublicKey(t, string(body))
+	if !bytes.Equal(keyID, signingKeyID[:]) {
+		t.Errorf("key ID = %X, want %X", keyID, signingKeyID)
+	}
+
+	status, body = s.request("GET", "/hello.sig", "", nil)
+	if status != http.StatusOK {
+		t.Fatalf("signature = %d %s", status, body)
+	}
+	trustedComment, err := verifyMinisign(keyID, key, string(body), []byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(trustedComment, "\tfile:hello\t") {
+		t.Errorf("trusted comment = %q, want the file name", trustedComment)
+	}
+
+	// Changed content and a changed trusted comment both fail to verify
+	if _, err := verifyMinisign(keyID, key, string(body), []byte(content+"rm -rf /\n")); err == nil {
+		t.Error("tampered content verified")
+	}
+	forged := strings.Replace(string(body), "file:hello", "file:other", 1)
+	if _, err := verifyMinisign(keyID, key, forged, []byte(content)); err == nil {
+		t.Error("tampered trusted comment verified")
+	}
+}
+
+func TestInitSigningKeepsKey(t *testing.T) {
+	newTestServer(t, "")
+	if err := initSigning(); err != nil {
+		t.Fatal(err)
+	}
+	public := minisignPublicKey()
+	key, err := os.ReadFile(filepath.Join(dataPath, "signing.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(key, []byte("PRIVATE KEY")) {
+		t.Errorf("signing key = %q", key)
+	}
+
+	// A restart loads the same key rather than generating a new one
+	signingKey = nil
+	if err := initSigning(); err != nil {
+		t.Fatal(err)
+	}
+	if got := minisignPublicKey(); got != public {
+		t.Errorf("public key changed across restarts: %q, want %q", got, public)
+	}
+}
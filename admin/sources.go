@@ -0,0 +1,344 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Local scripts with a git source are kept in sync with a file in a git
+// repository: the server fetches the ref periodically (or when the webhook
+// fires) and serves the file's content at the fetched commit.
+
+// gitSyncActor authors the revisions and audit entries of synced content
+const gitSyncActor = "git-sync"
+
+// GitSource points a local script at a file in a git repository
+type GitSource struct {
+	Repo string `yaml:"repo" json:"repo"`
+	Ref  string `yaml:"ref,omitempty" json:"ref,omitempty"` // branch or tag, default HEAD
+	Path string `yaml:"path" json:"path"`
+}
+
+func (g GitSource) ref() string {
+	if g.Ref == "" {
+		return "HEAD"
+	}
+	return g.Ref
+}
+
+var (
+	// scpLikeRepo is the user@host:path form of an ssh repository
+	scpLikeRepo = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*@[A-Za-z0-9][A-Za-z0-9.-]*:[^:\\-][^:\\]*$`)
+	validGitRef = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._/-]*$`)
+)
+
+// validate checks a source before it is saved. Git runs commands for some
+// transports (ext::, local paths with --upload-pack) and treats arguments
+// starting with '-' as options, so only https and ssh repositories and plain
+// branch or tag names are accepted.
+func (g GitSource) validate() error {
+	if g.Repo == "" || g.Path == "" {
+		return errors.New("repo and path are required")
+	}
+	if !validGitRepo(g.Repo) {
+		return errors.New("repo must be an https:// or ssh:// URL, or user@host:path")
+	}
+	if g.Ref != "" && (!validGitRef.MatchString(g.Ref) || strings.Contains(g.Ref, "..") || strings.HasSuffix(g.Ref, ".lock")) {
+		return fmt.Errorf("ref %q is not a branch or tag name", g.Ref)
+	}
+	return nil
+}
+
+func validGitRepo(repo string) bool {
+	if strings.ContainsAny(repo, " \t\n") || strings.Contains(repo, "::") {
+		return false
+	}
+	if scpLikeRepo.MatchString(repo) {
+		return true
+	}
+	u, err := url.Parse(repo)
+	if err != nil || u.Host == "" || strings.HasPrefix(u.Host, "-") || strings.HasPrefix(u.User.Username(), "-") {
+		return false
+	}
+	return u.Scheme == "https" || u.Scheme == "ssh"
+}
+
+func (g GitSource) String() string {
+	return fmt.Sprintf("%s@%s:%s", g.Repo, g.ref(), g.Path)
+}
+
+// SourceStatus is the outcome of the last sync of a git-sourced script.
+// Commit is the version currently served.
+type SourceStatus struct {
+	Commit      string     `json:"commit,omitempty"`
+	LastSync    time.Time  `json:"last_sync"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// SourceSyncer syncs git-sourced scripts and keeps their status in
+// DATA_PATH/sources.json
+type SourceSyncer struct {
+	mu       sync.Mutex
+	syncMu   sync.Mutex
+	path     string
+	statuses map[string]SourceStatus
+	trigger  chan struct{}
+}
+
+var sourceSyncer *SourceSyncer
+
+func newSourceSyncer(path string) *SourceSyncer {
+	s := &SourceSyncer{path: path, statuses: map[string]SourceStatus{}, trigger: make(chan struct{}, 1)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read sources file: %v", err)
+		}
+		return s
+	}
+	if err := json.Unmarshal(data, &s.statuses); err != nil {
+		log.Printf("Failed to parse sources file: %v", err)
+	}
+	return s
+}
+
+func (s *SourceSyncer) save() error {
+	data, err := json.MarshalIndent(s.statuses, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data, 0644)
+}
+
+// Status returns the last sync status of a script
+func (s *SourceSyncer) Status(name string) (SourceStatus, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status, ok := s.statuses[name]
+	return status, ok
+}
+
+// Trigger asks Run to sync now without waiting for the interval
+func (s *SourceSyncer) Trigger() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run syncs all git-sourced scripts at start, every interval and on Trigger
+func (s *SourceSyncer) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.SyncAll()
+		select {
+		case <-ticker.C:
+		case <-s.trigger:
+		}
+	}
+}
+
+type fetchResult struct {
+	commit string
+	err    error
+}
+
+// SyncAll fetches every source once per repository and ref and updates the
+// scripts whose content changed
+func (s *SourceSyncer) SyncAll() {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	fetched := map[string]fetchResult{}
+	statuses := map[string]SourceStatus{}
+	updated := false
+
+	for _, script := range configStore.Scripts() {
+		if script.Git == nil || script.Type != "local" {
+			continue
+		}
+		source := *script.Git
+
+		key := source.Repo + "\x00" + source.ref()
+		result, ok := fetched[key]
+		if !ok {
+			commit, err := fetchSource(source)
+			result = fetchResult{commit, err}
+			fetched[key] = result
+		}
+
+		status, _ := s.Status(script.Name)
+		status.LastSync = time.Now().UTC()
+		status.Error = ""
+
+		err := result.err
+		if err == nil {
+			var wrote bool
+			wrote, err = syncScriptContent(script, source, result.commit)
+			updated = updated || wrote
+		}
+		if err != nil {
+			status.Error = err.Error()
+			log.Printf("Failed to sync %s from %s: %v", script.Name, source, err)
+		} else {
+			status.Commit = result.commit
+			lastSuccess := status.LastSync
+			status.LastSuccess = &lastSuccess
+		}
+		statuses[script.Name] = status
+	}
+
+	s.mu.Lock()
+	s.statuses = statuses
+	if err := s.save(); err != nil {
+		log.Printf("Failed to save sources file: %v", err)
+	}
+	s.mu.Unlock()
+
+	// The index embeds each script's checksum
+	if updated {
+		updateIndexPageWithCurrentScripts()
+	}
+}
+
+// sourceCacheDir returns the bare repository caching fetches from repo
+func sourceCacheDir(repo string) string {
+	return filepath.Join(dataPath, "sources", contentHash([]byte(repo))[:16]+".git")
+}
+
+// fetchSource fetches the source's ref into the cache and returns its commit
+func fetchSource(source GitSource) (string, error) {
+	dir := sourceCacheDir(source.Repo)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", err
+		}
+		if _, err := runGit(dir, "init", "-q", "--bare"); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := runGit(dir, "fetch", "-q", "--depth=1", "--no-tags", "--end-of-options", source.Repo, source.ref()); err != nil {
+		return "", err
+	}
+	return runGit(dir, "rev-parse", "FETCH_HEAD")
+}
+
+// syncScriptContent replaces the script's content with the file at commit if
+// it differs. The commit is recorded with the revision so history shows which
+// version was served.
+func syncScriptContent(script ScriptConfig, source GitSource, commit string) (bool, error) {
+	data, err := runGitOutput(sourceCacheDir(source.Repo), "cat-file", "blob", commit+":"+strings.TrimPrefix(source.Path, "/"))
+	if err != nil {
+		return false, fmt.Errorf("%s not found at %s", source.Path, commit[:12])
+	}
+
+	path := localScriptFile(script)
+	if fileHash(path) == contentHash(data) {
+		return false, nil
+	}
+
+	if issues := lintScriptContent(script, data); lintHasErrors(issues) {
+		return false, fmt.Errorf("%s at %s: %s", source.Path, commit[:12], lintErrorMessage(issues))
+	}
+	if findings := scanContent(data); len(findings) > 0 {
+		return false, fmt.Errorf("%s at %s: content scan found %s", source.Path, commit[:12], findingRules(findings))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	rev, previousHash, err := writeScriptContent(script, data, gitSyncActor, "", commit)
+	if err != nil {
+		return false, err
+	}
+
+	log.Printf("Synced %s from %s at %s", script.Name, source, commit[:12])
+	writeAuditEntry(AuditEntry{
+		Actor:        gitSyncActor,
+		Action:       "script.sync",
+		Script:       script.Name,
+		BeforeSHA256: previousHash,
+		AfterSHA256:  rev.SHA256,
+		Details:      fmt.Sprintf("%s commit %s", source, commit),
+	})
+	return true, nil
+}
+
+// gitSourcesInterval reads GIT_SOURCES_INTERVAL, defaulting to five minutes
+func gitSourcesInterval() time.Duration {
+	if interval, err := time.ParseDuration(os.Getenv("GIT_SOURCES_INTERVAL")); err == nil && interval > 0 {
+		return interval
+	}
+	return 5 * time.Minute
+}
+
+// gitManagedError rejects content changes to scripts synced from git
+func gitManagedError(c *fiber.Ctx, script ScriptConfig) error {
+	return c.Status(409).JSON(fiber.Map{
+		"error": fmt.Sprintf("Content of %s is synced from %s; change it there", script.Name, script.Git),
+	})
+}
+
+// syncSourcesAPI syncs all git-sourced scripts now and returns their status
+func syncSourcesAPI(c *fiber.Ctx) error {
+	sourceSyncer.SyncAll()
+
+	statuses := map[string]SourceStatus{}
+	for _, script := range configStore.Scripts() {
+		if status, ok := sourceSyncer.Status(script.Name); ok {
+			statuses[script.Name] = status
+		}
+	}
+	return c.JSON(statuses)
+}
+
+// gitWebhookHandler starts a sync when a git host reports a push. It accepts
+// GitHub's X-Hub-Signature-256, GitLab's X-Gitlab-Token or a bearer token,
+// all checked against GIT_WEBHOOK_SECRET.
+func gitWebhookHandler(c *fiber.Ctx) error {
+	secret := os.Getenv("GIT_WEBHOOK_SECRET")
+	if secret == "" {
+		return c.Status(404).JSON(fiber.Map{"error": "Webhook is not enabled"})
+	}
+
+	authorized := false
+	if signature := c.Get("X-Hub-Signature-256"); signature != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(c.Body())
+		expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		authorized = hmac.Equal([]byte(signature), []byte(expected))
+	} else if token := c.Get("X-Gitlab-Token"); token != "" {
+		authorized = subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	} else if token := bearerToken(c); token != "" {
+		authorized = subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	}
+	if !authorized {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid webhook signature"})
+	}
+
+	sourceSyncer.Trigger()
+	return c.Status(202).JSON(fiber.Map{"message": "Sync started"})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestGitSourceValidate(t *testing.T) {
+	valid := []GitSource{
+		{Repo: "https://github.com/you/scripts.git", Path: "docker.sh"},
+		{Repo: "ssh://git@github.com/you/scripts.git", Ref: "main", Path: "docker.sh"},
+		{Repo: "git@github.com:you/scripts.git", Ref: "release/v1.2", Path: "install/docker.sh"},
+	}
+	for _, source := range valid {
+		if err := source.validate(); err != nil {
+			t.Errorf("validate(%s) = %v", source, err)
+		}
+	}
+
+	invalid := []GitSource{
+		{Repo: "https://github.com/you/scripts.git"},
+		{Repo: "/tmp/r", Path: "a"},
+		{Repo: "../r", Path: "a"},
+		{Repo: "file:///tmp/r", Path: "a"},
+		{Repo: "ext::sh -c touch% /tmp/pwned", Path: "a"},
+		{Repo: "fd::17", Path: "a"},
+		{Repo: "http://example.com/scripts.git", Path: "a"},
+		{Repo: "-uxxx", Path: "a"},
+		{Repo: "ssh://-oProxyCommand=touch%20x/r", Path: "a"},
+		{Repo: "-oProxyCommand=x@host:r", Path: "a"},
+		{Repo: "git@host:-r", Path: "a"},
+		{Repo: "https://github.com/you/scripts.git", Ref: "--upload-pack=touch /tmp/pwned;false", Path: "a"},
+		{Repo: "https://github.com/you/scripts.git", Ref: "main..other", Path: "a"},
+		{Repo: "https://github.com/you/scripts.git", Ref: "main branch", Path: "a"},
+	}
+	for _, source := range invalid {
+		if err := source.validate(); err == nil {
+			t.Errorf("validate(%s) accepted an invalid source", source)
+		}
+	}
+}
+
+func TestCreateScriptRefusesUnsafeGitSource(t *testing.T) {
+	s := newTestServer(t, "scripts: []\n")
+	editor := s.token("editor", scopeFull)
+	marker := filepath.Join(t.TempDir(), "pwned")
+
+	sources := []fiber.Map{
+		{"repo": "/tmp/r", "ref": "--upload-pack=touch " + marker + ";false", "path": "a"},
+		{"repo": "ext::sh -c touch% " + marker, "path": "a"},
+		{"repo": "https://github.com/you/scripts.git", "ref": "--upload-pack=touch " + marker, "path": "a"},
+	}
+	for _, source := range sources {
+		status, body := s.request("POST", "/admin/scripts", editor, fiber.Map{"name": "evil", "description": "x", "git": source})
+		if status != 400 || !strings.Contains(string(body), "Invalid git source") {
+			t.Errorf("create with git source %v = %d %s, want 400", source, status, body)
+		}
+	}
+	if scripts := configStore.Scripts(); len(scripts) != 0 {
+		t.Fatalf("scripts saved: %+v", scripts)
+	}
+	if _, err := os.Stat(filepath.Join(scriptsPath, "evil_dir")); !os.IsNotExist(err) {
+		t.Error("files of a refused script were left behind")
+	}
+
+	// A config update that slips past the API check is still refused
+	err := configStore.Update(func(cfg *Config) error {
+		cfg.Scripts = append(cfg.Scripts, ScriptConfig{Name: "evil", Type: "local", Git: &GitSource{Repo: "/tmp/r", Path: "a"}})
+		return nil
+	})
+	if err == nil {
+		t.Error("Update saved a local git repository")
+	}
+}
+
+// TestFetchSourceEndOfOptions checks that a ref is never taken for an
+// option, even when validation was bypassed by editing config.yaml
+func TestFetchSourceEndOfOptions(t *testing.T) {
+	swapGlobal(t, &dataPath, t.TempDir())
+	remote := filepath.Join(t.TempDir(), "remote.git")
+	if _, err := runGit(filepath.Dir(remote), "init", "-q", "--bare", remote); err != nil {
+		t.Fatal(err)
+	}
+	marker := filepath.Join(t.TempDir(), "pwned")
+
+	_, err := fetchSource(GitSource{Repo: remote, Ref: "--upload-pack=touch " + marker + ";false", Path: "a"})
+	if err == nil {
+		t.Error("fetch of an option-like ref succeeded")
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Fatal("ref was run as --upload-pack")
+	}
+}
+
+func TestGitWebhookAuth(t *testing.T) {
+	s := newTestServer(t, "scripts: []\n")
+	body := `{"ref":"refs/heads/main"}`
+	mac := hmac.New(sha256.New, []byte("hooksecret"))
+	mac.Write([]byte(body))
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	send := func(header, value string) int {
+		t.Helper()
+		req, _ := http.NewRequest("POST", "/hooks/git", strings.NewReader(body))
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		status, _ := s.do(req)
+		return status
+	}
+
+	if status := send("Authorization", "Bearer hooksecret"); status != 404 {
+		t.Errorf("without GIT_WEBHOOK_SECRET = %d, want 404", status)
+	}
+	t.Setenv("GIT_WEBHOOK_SECRET", "hooksecret")
+
+	tests := []struct {
+		name, header, value string
+		want                int
+	}{
+		{"github", "X-Hub-Signature-256", signature, 202},
+		{"github wrong signature", "X-Hub-Signature-256", "sha256=" + strings.Repeat("0", 64), 401},
+		{"github unprefixed", "X-Hub-Signature-256", strings.TrimPrefix(signature, "sha256="), 401},
+		{"gitlab", "X-Gitlab-Token", "hooksecret", 202},
+		{"gitlab wrong token", "X-Gitlab-Token", "other", 401},
+		{"bearer", "Authorization", "Bearer hooksecret", 202},
+		{"bearer wrong token", "Authorization", "Bearer other", 401},
+		{"no credentials", "", "", 401},
+	}
+	for _, tt := range tests {
+		// Drain the trigger so each case shows whether it started a sync
+		select {
+		case <-sourceSyncer.trigger:
+		default:
+		}
+		if status := send(tt.header, tt.value); status != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, status, tt.want)
+		}
+		if triggered := len(sourceSyncer.trigger) > 0; triggered != (tt.want == 202) {
+			t.Errorf("%s: sync triggered = %v", tt.name, triggered)
+		}
+	}
+}
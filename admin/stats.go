@@ -0,0 +1,281 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// statsRetentionDays bounds how many per-day buckets are kept per script
+const statsRetentionDays = 365
+
+// ScriptStats holds the download counters of one script. Client addresses
+// are stored per day as hashes keyed with a salt that changes daily and is
+// never written to disk, so they cannot be reversed or linked across days,
+// and they are dropped with the day's bucket.
+type ScriptStats struct {
+	Total        int64                      `json:"total"`
+	Daily        map[string]int64           `json:"daily"`
+	DailyClients map[string]map[string]bool `json:"daily_clients"`
+	UserAgents   map[string]int64           `json:"user_agents"`
+	LastDownload *time.Time                 `json:"last_download,omitempty"`
+}
+
+// StatsStore counts script downloads and persists them to
+// DATA_PATH/stats.json. Counting only touches memory; Flush writes the file.
+type StatsStore struct {
+	mu      sync.Mutex
+	path    string
+	scripts map[string]*ScriptStats
+	dirty   bool
+	// salt keys the client hashes of saltDay
+	salt    []byte
+	saltDay string
+}
+
+var statsStore *StatsStore
+
+func newStatsStore(path string) *StatsStore {
+	s := &StatsStore{path: path, scripts: map[string]*ScriptStats{}}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read stats file: %v", err)
+		}
+		return s
+	}
+	if err := json.Unmarshal(data, &s.scripts); err != nil {
+		log.Printf("Failed to parse stats file: %v", err)
+	}
+	return s
+}
+
+// Record counts one download of a script
+func (s *StatsStore) Record(name, ip, userAgent string) {
+	now := time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, ok := s.scripts[name]
+	if !ok {
+		stats = &ScriptStats{}
+		s.scripts[name] = stats
+	}
+	if stats.Daily == nil {
+		stats.Daily = map[string]int64{}
+	}
+	if stats.DailyClients == nil {
+		stats.DailyClients = map[string]map[string]bool{}
+	}
+	if stats.UserAgents == nil {
+		stats.UserAgents = map[string]int64{}
+	}
+
+	day := now.Format("2006-01-02")
+	if stats.DailyClients[day] == nil {
+		stats.DailyClients[day] = map[string]bool{}
+	}
+
+	stats.Total++
+	stats.Daily[day]++
+	stats.DailyClients[day][s.clientHash(ip, day)] = true
+	stats.UserAgents[userAgentFamily(userAgent)]++
+	stats.LastDownload = &now
+	s.dirty = true
+}
+
+// clientHash returns the hash a client address is counted under on day.
+// s.mu must be held.
+func (s *StatsStore) clientHash(ip, day string) string {
+	if day != s.saltDay {
+		s.salt = make([]byte, 32)
+		if _, err := rand.Read(s.salt); err != nil {
+			log.Printf("Failed to generate stats salt: %v", err)
+		}
+		s.saltDay = day
+	}
+	mac := hmac.New(sha256.New, s.salt)
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// Delete drops the counters of a removed script
+func (s *StatsStore) Delete(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.scripts[name]; ok {
+		delete(s.scripts, name)
+		s.dirty = true
+	}
+}
+
+// Flush writes the counters to disk if they changed, pruning day buckets
+// older than the retention window.
+func (s *StatsStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+
+	cutoff := time.Now().UTC().AddDate(0, 0, -statsRetentionDays).Format("2006-01-02")
+	for _, stats := range s.scripts {
+		for day := range stats.Daily {
+			if day < cutoff {
+				delete(stats.Daily, day)
+			}
+		}
+		for day := range stats.DailyClients {
+			if day < cutoff {
+				delete(stats.DailyClients, day)
+			}
+		}
+	}
+
+	data, err := json.Marshal(s.scripts)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, data, 0644); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// Run flushes the counters periodically
+func (s *StatsStore) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := s.Flush(); err != nil {
+			log.Printf("Failed to save download stats: %v", err)
+		}
+	}
+}
+
+// DailyCount is the number of downloads and distinct clients on one day
+type DailyCount struct {
+	Date    string `json:"date"`
+	Count   int64  `json:"count"`
+	Clients int    `json:"clients"`
+}
+
+// StatsSummary is the API view of a script's download counters
+type StatsSummary struct {
+	Script string `json:"script"`
+	Total  int64  `json:"total"`
+	// UniqueIPs adds up the distinct clients of each day in the window;
+	// clients cannot be matched across days
+	UniqueIPs    int              `json:"unique_ips"`
+	UserAgents   map[string]int64 `json:"user_agents"`
+	Daily        []DailyCount     `json:"daily"`
+	LastDownload *time.Time       `json:"last_download,omitempty"`
+}
+
+// Summary returns the counters of a script with one bucket for each of the
+// last `days` days, oldest first, including days without downloads.
+func (s *StatsStore) Summary(name string, days int) StatsSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	summary := StatsSummary{Script: name, UserAgents: map[string]int64{}, Daily: []DailyCount{}}
+	stats := s.scripts[name]
+	if stats != nil {
+		summary.Total = stats.Total
+		summary.LastDownload = stats.LastDownload
+		for family, count := range stats.UserAgents {
+			summary.UserAgents[family] = count
+		}
+	}
+
+	today := time.Now().UTC()
+	for i := days - 1; i >= 0; i-- {
+		day := today.AddDate(0, 0, -i).Format("2006-01-02")
+		bucket := DailyCount{Date: day}
+		if stats != nil {
+			bucket.Count = stats.Daily[day]
+			bucket.Clients = len(stats.DailyClients[day])
+		}
+		summary.UniqueIPs += bucket.Clients
+		summary.Daily = append(summary.Daily, bucket)
+	}
+	return summary
+}
+
+// userAgentFamily reduces a User-Agent header to the client family
+func userAgentFamily(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	families := []struct{ match, family string }{
+		{"curl", "curl"},
+		{"wget", "wget"},
+		{"powershell", "powershell"},
+		{"python", "python"},
+		{"go-http-client", "go"},
+		{"ansible", "ansible"},
+		{"mozilla", "browser"},
+	}
+	for _, f := range families {
+		if strings.Contains(ua, f.match) {
+			return f.family
+		}
+	}
+	if ua == "" {
+		return "unknown"
+	}
+	return "other"
+}
+
+func statsDaysParam(c *fiber.Ctx) (int, bool) {
+	days, err := strconv.Atoi(c.Query("days", "30"))
+	if err != nil || days < 1 || days > statsRetentionDays {
+		return 0, false
+	}
+	return days, true
+}
+
+func scriptStatsAPI(c *fiber.Ctx) error {
+	script, ok := findScript(c.Params("name"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Script not found"})
+	}
+	days, ok := statsDaysParam(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "days must be between 1 and 365"})
+	}
+	return c.JSON(statsStore.Summary(script.Name, days))
+}
+
+// allStatsAPI returns the summaries of every configured script, used for the
+// dashboard sparklines
+func allStatsAPI(c *fiber.Ctx) error {
+	days, ok := statsDaysParam(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "days must be between 1 and 365"})
+	}
+
+	summaries := []StatsSummary{}
+	for _, script := range configStore.Scripts() {
+		summaries = append(summaries, statsStore.Summary(script.Name, days))
+	}
+	return c.JSON(summaries)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsClientHashRotatesDaily(t *testing.T) {
+	store := newStatsStore(filepath.Join(t.TempDir(), "stats.json"))
+
+	first := store.clientHash("192.0.2.1", "2024-01-01")
+	if again := store.clientHash("192.0.2.1", "2024-01-01"); again != first {
+		t.Errorf("same client and day hashed to %s and %s", first, again)
+	}
+	if other := store.clientHash("192.0.2.2", "2024-01-01"); other == first {
+		t.Error("different clients share a hash")
+	}
+	if next := store.clientHash("192.0.2.1", "2024-01-02"); next == first {
+		t.Error("client hash did not change with the day")
+	}
+	if first == contentHash([]byte("192.0.2.1"))[:16] {
+		t.Error("client hash is not salted")
+	}
+}
+
+func TestStatsFlushDropsOldClients(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	store := newStatsStore(path)
+	store.Record("docker", "192.0.2.1", "curl/8.0")
+	store.Record("docker", "192.0.2.1", "curl/8.0")
+	store.Record("docker", "192.0.2.2", "Wget/1.21")
+
+	old := time.Now().UTC().AddDate(0, 0, -statsRetentionDays-1).Format("2006-01-02")
+	store.scripts["docker"].Daily[old] = 1
+	store.scripts["docker"].DailyClients[old] = map[string]bool{"0123456789abcdef": true}
+
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), old) || strings.Contains(string(data), "192.0.2") {
+		t.Errorf("stats file keeps old days or raw addresses: %s", data)
+	}
+
+	var saved map[string]*ScriptStats
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	today := time.Now().UTC().Format("2006-01-02")
+	if clients := len(saved["docker"].DailyClients[today]); clients != 2 {
+		t.Errorf("clients today = %d, want 2", clients)
+	}
+
+	summary := store.Summary("docker", 1)
+	if summary.UniqueIPs != 2 || summary.Daily[0].Clients != 2 || summary.Daily[0].Count != 3 {
+		t.Errorf("summary = %+v, want 3 downloads from 2 clients", summary)
+	}
+}
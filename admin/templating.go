@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Local scripts with template: true are Go text/template files rendered on
+// every download. Query parameters fill in the params the script declares,
+// so /k3s and /k3s?version=1.29 serve variants of one script. Values are
+// checked against the declared schema before they reach the template.
+
+const maxParamLength = 256
+
+var (
+	validParamName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// safeParamValue is what a string param without allowed values or a
+	// pattern accepts: nothing a shell would interpret
+	safeParamValue = regexp.MustCompile(`^[A-Za-z0-9._,:/@+=-]*$`)
+	validHost      = regexp.MustCompile(`^[A-Za-z0-9.-]+(:[0-9]+)?$`)
+	templateLine   = regexp.MustCompile(`^template: [^:]*:(\d+)(?::(\d+))?: `)
+)
+
+// ScriptParam declares a query parameter of a template script
+type ScriptParam struct {
+	Name        string `yaml:"name" json:"name"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	Type        string `yaml:"type,omitempty" json:"type,omitempty"` // "string" (default), "int" or "bool"
+	// Default is used when the query does not set the param; required
+	// params have none
+	Default  string   `yaml:"default,omitempty" json:"default,omitempty"`
+	Required bool     `yaml:"required,omitempty" json:"required,omitempty"`
+	Allowed  []string `yaml:"allowed,omitempty" json:"allowed,omitempty"`
+	// Pattern is a regular expression the whole value must match. It
+	// replaces the default restriction to characters that are safe in shell.
+	Pattern string `yaml:"pattern,omitempty" json:"pattern,omitempty"`
+}
+
+// paramPatterns caches the compiled, anchored form of param patterns, which
+// are checked on every download of a template script
+var (
+	paramPatternsMu sync.Mutex
+	paramPatterns   = map[string]*regexp.Regexp{}
+)
+
+// compileParamPattern returns a regular expression matching whole values
+// against pattern, compiling it on first use
+func compileParamPattern(pattern string) (*regexp.Regexp, error) {
+	paramPatternsMu.Lock()
+	defer paramPatternsMu.Unlock()
+	if re, ok := paramPatterns[pattern]; ok {
+		return re, nil
+	}
+	// The pattern must be valid on its own, not only once wrapped in a group
+	if _, err := regexp.Compile(pattern); err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return nil, err
+	}
+	paramPatterns[pattern] = re
+	return re, nil
+}
+
+// parse checks a value against the param's schema and converts it to the
+// param's type
+func (p ScriptParam) parse(raw string) (any, error) {
+	if len(raw) > maxParamLength {
+		return nil, fmt.Errorf("%s is longer than %d characters", p.Name, maxParamLength)
+	}
+	if len(p.Allowed) > 0 {
+		allowed := false
+		for _, value := range p.Allowed {
+			allowed = allowed || value == raw
+		}
+		if !allowed {
+			return nil, fmt.Errorf("%s must be one of: %s", p.Name, strings.Join(p.Allowed, ", "))
+		}
+	}
+	if p.Pattern != "" {
+		pattern, err := compileParamPattern(p.Pattern)
+		if err != nil || !pattern.MatchString(raw) {
+			return nil, fmt.Errorf("%s does not match %s", p.Name, p.Pattern)
+		}
+	}
+
+	switch p.Type {
+	case "int":
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an integer", p.Name)
+		}
+		return n, nil
+	case "bool":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false", p.Name)
+		}
+		return b, nil
+	}
+	if len(p.Allowed) == 0 && p.Pattern == "" && !safeParamValue.MatchString(raw) {
+		return nil, fmt.Errorf("%s may only contain letters, digits and . _ , : / @ + = -", p.Name)
+	}
+	return raw, nil
+}
+
+// validateTemplateFields checks a script's template flag and param schema
+func validateTemplateFields(script ScriptConfig) error {
+	if !script.Template {
+		if len(script.Params) > 0 {
+			return fmt.Errorf("script %q has params but is not a template", script.Name)
+		}
+		return nil
+	}
+	if script.Type != "" && script.Type != "local" {
+		return fmt.Errorf("script %q is a template but not local", script.Name)
+	}
+
+	seen := map[string]bool{}
+	for _, param := range script.Params {
+		if !validParamName.MatchString(param.Name) {
+			return fmt.Errorf("script %q has invalid param name %q", script.Name, param.Name)
+		}
+		if seen[param.Name] {
+			return fmt.Errorf("script %q has duplicate param %q", script.Name, param.Name)
+		}
+		seen[param.Name] = true
+
+		switch param.Type {
+		case "", "string", "int", "bool":
+		default:
+			return fmt.Errorf("param %q of script %q has unknown type %q", param.Name, script.Name, param.Type)
+		}
+		if param.Pattern != "" {
+			if _, err := compileParamPattern(param.Pattern); err != nil {
+				return fmt.Errorf("param %q of script %q has invalid pattern: %v", param.Name, script.Name, err)
+			}
+		}
+		if param.Required {
+			if param.Default != "" {
+				return fmt.Errorf("param %q of script %q is required and cannot have a default", param.Name, script.Name)
+			}
+			continue
+		}
+		if _, err := param.parse(param.Default); err != nil {
+			return fmt.Errorf("default of param %q of script %q is invalid: %v", param.Name, script.Name, err)
+		}
+	}
+	return nil
+}
+
+// templateData is what a script template is executed with
+type templateData struct {
+	Params map[string]any
+	// Host and BaseURL are where the script was requested from, e.g.
+	// "get.example.com" and "https://get.example.com"
+	Host    string
+	BaseURL string
+	Script  string
+}
+
+// requestTemplateData fills in a template's params from the query string.
+// Errors are the client's and are worded for it.
+func requestTemplateData(c *fiber.Ctx, script ScriptConfig) (templateData, error) {
+	query := map[string]string{}
+	c.Context().QueryArgs().VisitAll(func(key, value []byte) {
+		if _, ok := query[string(key)]; !ok {
+			query[string(key)] = string(value)
+		}
+	})
+
+	data := templateData{Params: map[string]any{}, Script: script.Name}
+	for _, param := range script.Params {
+		raw, ok := query[param.Name]
+		delete(query, param.Name)
+		if !ok {
+			if param.Required {
+				return data, fmt.Errorf("%s is required", param.Name)
+			}
+			raw = param.Default
+		}
+		value, err := param.parse(raw)
+		if err != nil {
+			return data, err
+		}
+		data.Params[param.Name] = value
+	}
+	// A misspelt param would otherwise silently serve the default
+	if len(query) > 0 {
+		var unknown []string
+		for name := range query {
+			unknown = append(unknown, name)
+		}
+		sort.Strings(unknown)
+		return data, fmt.Errorf("unknown parameter %s; %s", strings.Join(unknown, ", "), paramUsage(script))
+	}
+
+	base, err := url.Parse(publicBaseURL(c))
+	if err != nil || !validHost.MatchString(base.Host) {
+		return data, errors.New("invalid host")
+	}
+	data.Host = base.Hostname()
+	data.BaseURL = base.Scheme + "://" + base.Host
+	return data, nil
+}
+
+// renderRequest renders a template script for a download. Errors are
+// *fiber.Error, for handlers to return as the response.
+func renderRequest(c *fiber.Ctx, script ScriptConfig, content []byte) ([]byte, error) {
+	data, err := requestTemplateData(c, script)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	rendered, err := renderTemplate(script, content, data)
+	if err != nil {
+		log.Printf("Failed to render template %s: %v", script.Name, err)
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to render script")
+	}
+	return rendered, nil
+}
+
+// paramUsage lists the params a script accepts for error messages
+func paramUsage(script ScriptConfig) string {
+	if len(script.Params) == 0 {
+		return "this script takes no parameters"
+	}
+	var names []string
+	for _, param := range script.Params {
+		names = append(names, param.Name)
+	}
+	return "accepted: " + strings.Join(names, ", ")
+}
+
+// sampleTemplateData stands in for a request when checking a template: params
+// take their default, else their first allowed value, else a zero value
+func sampleTemplateData(script ScriptConfig) templateData {
+	data := templateData{
+		Params:  map[string]any{},
+		Host:    "example.com",
+		BaseURL: "https://example.com",
+		Script:  script.Name,
+	}
+	for _, param := range script.Params {
+		raw := param.Default
+		if param.Required && len(param.Allowed) > 0 {
+			raw = param.Allowed[0]
+		}
+		if value, err := param.parse(raw); err == nil {
+			data.Params[param.Name] = value
+			continue
+		}
+		switch param.Type {
+		case "int":
+			data.Params[param.Name] = 0
+		case "bool":
+			data.Params[param.Name] = false
+		default:
+			data.Params[param.Name] = ""
+		}
+	}
+	return data
+}
+
+var templateFuncs = template.FuncMap{
+	// quote single-quotes a value for shell
+	"quote": func(value any) string {
+		return "'" + strings.ReplaceAll(fmt.Sprint(value), "'", `'\''`) + "'"
+	},
+}
+
+// renderTemplate executes a script template. Params the script does not
+// declare are an error rather than an empty string.
+func renderTemplate(script ScriptConfig, content []byte, data templateData) ([]byte, error) {
+	tmpl, err := template.New(script.Name).Funcs(templateFuncs).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// checkTemplateContent checks that a script's current content renders as a
+// template with the script's params
+func checkTemplateContent(script ScriptConfig) error {
+	content, err := os.ReadFile(localScriptFile(script))
+	if err != nil {
+		return fmt.Errorf("cannot read content of script %q: %v", script.Name, err)
+	}
+	if issues := lintScriptContent(script, content); lintHasErrors(issues) {
+		return errors.New(lintErrorMessage(issues))
+	}
+	return nil
+}
+
+// lintScriptContent lints content for a script. Templates are rendered with
+// sample values first, so reported lines are those of the rendered script.
+func lintScriptContent(script ScriptConfig, content []byte) []LintIssue {
+	if !script.Template {
+		return lintScript(script.Name, content)
+	}
+	rendered, err := renderTemplate(script, content, sampleTemplateData(script))
+	if err != nil {
+		issue := LintIssue{Line: 1, Column: 1, Rule: "template", Severity: "error", Message: err.Error()}
+		if match := templateLine.FindStringSubmatch(err.Error()); match != nil {
+			line, _ := strconv.Atoi(match[1])
+			issue.Line = uint(line)
+			if column, err := strconv.Atoi(match[2]); err == nil {
+				issue.Column = uint(column)
+			}
+			issue.Message = strings.TrimPrefix(err.Error(), match[0])
+		}
+		return []LintIssue{issue}
+	}
+	return lintScript(script.Name, rendered)
+}
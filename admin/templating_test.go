@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScriptParamPattern(t *testing.T) {
+	param := ScriptParam{Name: "version", Required: true, Pattern: `1\.[0-9]+`}
+	script := ScriptConfig{Name: "k3s", Type: "local", Template: true, Params: []ScriptParam{param}}
+	if err := validateTemplateFields(script); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := param.parse("1.29"); err != nil || value != "1.29" {
+		t.Errorf("parse(1.29) = %v, %v", value, err)
+	}
+	// The pattern must match the whole value
+	for _, raw := range []string{"1.29; rm -rf /", "v1.29", "2.0"} {
+		if _, err := param.parse(raw); err == nil {
+			t.Errorf("parse(%q) accepted a value outside the pattern", raw)
+		}
+	}
+
+	first, _ := compileParamPattern(param.Pattern)
+	if again, _ := compileParamPattern(param.Pattern); again != first {
+		t.Error("pattern was compiled again")
+	}
+
+	// Patterns that are only valid once wrapped in the anchoring group are
+	// rejected
+	script.Params[0].Pattern = `a)|(b`
+	if err := validateTemplateFields(script); err == nil {
+		t.Errorf("pattern %q was accepted", script.Params[0].Pattern)
+	}
+}
+
+func TestCheckTemplateContent(t *testing.T) {
+	oldScriptsPath := scriptsPath
+	scriptsPath = t.TempDir()
+	t.Cleanup(func() { scriptsPath = oldScriptsPath })
+
+	script := ScriptConfig{
+		Name:     "k3s",
+		Type:     "local",
+		Template: true,
+		Params:   []ScriptParam{{Name: "channel", Default: "stable"}},
+	}
+	if err := checkTemplateContent(script); err == nil || !strings.Contains(err.Error(), "cannot read content") {
+		t.Errorf("checkTemplateContent() without content = %v, want a read error", err)
+	}
+
+	path := filepath.Join(scriptsPath, "k3s.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\necho {{ quote .Params.channel }}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkTemplateContent(script); err != nil {
+		t.Errorf("checkTemplateContent() = %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("#!/bin/sh\necho {{ .Params.version }}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkTemplateContent(script); err == nil {
+		t.Error("template using an undeclared param was accepted")
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	tokenPrefix = "ssd_"
+
+	scopeRead         = "read"
+	scopeContentWrite = "content-write"
+	scopeFull         = "full"
+)
+
+var tokenScopes = map[string]bool{
+	scopeRead:         true,
+	scopeContentWrite: true,
+	scopeFull:         true,
+}
+
+var errTokenNotFound = errors.New("token not found")
+
+// APIToken is a personal access token. Only the SHA-256 of the secret is
+// stored; the secret itself is shown once when the token is created.
+type APIToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Owner      string     `json:"owner"`
+	Scope      string     `json:"scope"`
+	Hash       string     `json:"hash,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func (t APIToken) expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// TokenStore keeps API tokens in DATA_PATH/tokens.json
+type TokenStore struct {
+	mu     sync.Mutex
+	path   string
+	tokens []APIToken
+}
+
+var tokenStore *TokenStore
+
+func newTokenStore(path string) *TokenStore {
+	s := &TokenStore{path: path}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read tokens file: %v", err)
+		}
+		return s
+	}
+	if err := json.Unmarshal(data, &s.tokens); err != nil {
+		log.Printf("Failed to parse tokens file: %v", err)
+	}
+	return s
+}
+
+// save writes tokens to disk. Callers only replace s.tokens once it succeeds.
+func (s *TokenStore) save(tokens []APIToken) error {
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data, 0600)
+}
+
+// Create generates a new token and returns it together with its secret
+func (s *TokenStore) Create(owner, name, scope string, expiresAt *time.Time) (APIToken, string, error) {
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return APIToken{}, "", err
+	}
+	idBytes := make([]byte, 6)
+	if _, err := rand.Read(idBytes); err != nil {
+		return APIToken{}, "", err
+	}
+
+	secret := tokenPrefix + hex.EncodeToString(secretBytes)
+	token := APIToken{
+		ID:        hex.EncodeToString(idBytes),
+		Name:      name,
+		Owner:     owner,
+		Scope:     scope,
+		Hash:      contentHash([]byte(secret)),
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := append(append([]APIToken(nil), s.tokens...), token)
+	if err := s.save(tokens); err != nil {
+		return APIToken{}, "", err
+	}
+	s.tokens = tokens
+	return token, secret, nil
+}
+
+// Authenticate returns the token matching secret if it exists and has not
+// expired, recording when it was last used.
+func (s *TokenStore) Authenticate(secret string) (APIToken, bool) {
+	hash := contentHash([]byte(secret))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, token := range s.tokens {
+		if token.Hash != hash {
+			continue
+		}
+		if token.expired() {
+			return APIToken{}, false
+		}
+
+		// Persist last use at most once a minute to keep API calls cheap
+		now := time.Now().UTC()
+		if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > time.Minute {
+			s.tokens[i].LastUsedAt = &now
+			if err := s.save(s.tokens); err != nil {
+				log.Printf("Failed to record token use: %v", err)
+			}
+		}
+		return s.tokens[i], true
+	}
+	return APIToken{}, false
+}
+
+// List returns the tokens of owner, or all tokens when owner is empty
+func (s *TokenStore) List(owner string) []APIToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := []APIToken{}
+	for _, token := range s.tokens {
+		if owner == "" || token.Owner == owner {
+			token.Hash = ""
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+	})
+	return tokens
+}
+
+// Revoke deletes a token. Unless owner is empty, only that user's tokens
+// can be revoked.
+func (s *TokenStore) Revoke(id, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, token := range s.tokens {
+		if token.ID == id && (owner == "" || token.Owner == owner) {
+			tokens := append(append([]APIToken(nil), s.tokens[:i]...), s.tokens[i+1:]...)
+			if err := s.save(tokens); err != nil {
+				return err
+			}
+			s.tokens = tokens
+			return nil
+		}
+	}
+	return errTokenNotFound
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header
+func bearerToken(c *fiber.Ctx) string {
+	auth := c.Get(fiber.HeaderAuthorization)
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// Routes are matched by method and registered path, not by what the request
+// path happens to end with.
+var (
+	// readOnlyRoutes are the non-GET routes that change nothing
+	readOnlyRoutes = map[string]bool{
+		"POST /admin/scripts/:name/diff": true,
+		"POST /admin/scripts/:name/lint": true,
+	}
+	// contentWriteRoutes are the routes a content-write token may use besides
+	// the read-only ones
+	contentWriteRoutes = map[string]bool{
+		"PUT /admin/scripts/:name/content":                 true,
+		"POST /admin/scripts/:name/revisions/:id/rollback": true,
+		"DELETE /admin/scripts/:name/draft":                true,
+	}
+)
+
+// scopeAllows reports whether a token scope permits the request. Scopes only
+// narrow access; the owner's role is still enforced by requireRole.
+func scopeAllows(scope string, c *fiber.Ctx) bool {
+	method := c.Method()
+	route := method + " " + c.Route().Path
+
+	readOnly := method == fiber.MethodGet || method == fiber.MethodHead || readOnlyRoutes[route]
+
+	switch scope {
+	case scopeFull:
+		return true
+	case scopeContentWrite:
+		return readOnly || contentWriteRoutes[route]
+	case scopeRead:
+		return readOnly
+	}
+	return false
+}
+
+func listTokensAPI(c *fiber.Ctx) error {
+	user, _ := c.Locals("user").(User)
+	owner := user.Username
+	if user.Role == roleAdmin && c.Query("all") == "true" {
+		owner = ""
+	}
+	return c.JSON(tokenStore.List(owner))
+}
+
+func createTokenAPI(c *fiber.Ctx) error {
+	// A leaked token must not be able to mint more tokens
+	if c.Locals("token") != nil {
+		return c.Status(403).JSON(fiber.Map{"error": "Tokens can only be created from a dashboard session"})
+	}
+
+	var body struct {
+		Name          string `json:"name"`
+		Scope         string `json:"scope"`
+		ExpiresInDays int    `json:"expires_in_days"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if strings.TrimSpace(body.Name) == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Token name is required"})
+	}
+	if body.Scope == "" {
+		body.Scope = scopeRead
+	}
+	if !tokenScopes[body.Scope] {
+		return c.Status(400).JSON(fiber.Map{"error": "Scope must be read, content-write or full"})
+	}
+	if body.ExpiresInDays < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "expires_in_days cannot be negative"})
+	}
+
+	var expiresAt *time.Time
+	if body.ExpiresInDays > 0 {
+		t := time.Now().UTC().AddDate(0, 0, body.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	token, secret, err := tokenStore.Create(currentUser(c), strings.TrimSpace(body.Name), body.Scope, expiresAt)
+	if err != nil {
+		log.Printf("Failed to create token: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create token"})
+	}
+
+	log.Printf("User %s created API token %s (%s, scope %s)", token.Owner, token.ID, token.Name, token.Scope)
+	recordAudit(c, AuditEntry{Action: "token.create", Details: fmt.Sprintf("token=%s name=%s scope=%s", token.ID, token.Name, token.Scope)})
+	token.Hash = ""
+	return c.JSON(fiber.Map{
+		"token":  token,
+		"secret": secret,
+	})
+}
+
+func revokeTokenAPI(c *fiber.Ctx) error {
+	user, _ := c.Locals("user").(User)
+	owner := user.Username
+	if user.Role == roleAdmin {
+		owner = ""
+	}
+
+	if err := tokenStore.Revoke(c.Params("id"), owner); err == errTokenNotFound {
+		return c.Status(404).JSON(fiber.Map{"error": "Token not found"})
+	} else if err != nil {
+		log.Printf("Failed to revoke token: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to revoke token"})
+	}
+
+	log.Printf("User %s revoked API token %s", user.Username, c.Params("id"))
+	recordAudit(c, AuditEntry{Action: "token.revoke", Details: "token=" + c.Params("id")})
+	return c.JSON(fiber.Map{"message": "Token revoked successfully"})
+}
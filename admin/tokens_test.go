@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTokenScopes(t *testing.T) {
+	s := newTestServer(t, "scripts: []\n")
+	secrets := map[string]string{
+		scopeRead:         s.token("admin", scopeRead),
+		scopeContentWrite: s.token("admin", scopeContentWrite),
+		scopeFull:         s.token("admin", scopeFull),
+	}
+
+	// The routes act on a script that does not exist, so that allowed
+	// requests reach the handler without changing anything
+	tests := []struct {
+		method, path string
+		allowed      []string
+	}{
+		{"GET", "/admin/scripts", []string{scopeRead, scopeContentWrite, scopeFull}},
+		{"GET", "/admin/scripts/missing/content", []string{scopeRead, scopeContentWrite, scopeFull}},
+		{"POST", "/admin/scripts/missing/diff", []string{scopeRead, scopeContentWrite, scopeFull}},
+		{"POST", "/admin/scripts/missing/lint", []string{scopeRead, scopeContentWrite, scopeFull}},
+		{"PUT", "/admin/scripts/missing/content", []string{scopeContentWrite, scopeFull}},
+		{"POST", "/admin/scripts/missing/revisions/1/rollback", []string{scopeContentWrite, scopeFull}},
+		{"DELETE", "/admin/scripts/missing/draft", []string{scopeContentWrite, scopeFull}},
+		{"POST", "/admin/scripts", []string{scopeFull}},
+		{"DELETE", "/admin/scripts/missing", []string{scopeFull}},
+		{"POST", "/admin/scripts/missing/draft/approve", []string{scopeFull}},
+		{"POST", "/admin/users/missing/password", []string{scopeFull}},
+		// Scripts named after an allowed route's last segment are matched
+		// on the route, not on how the path ends
+		{"PUT", "/admin/scripts/content", []string{scopeFull}},
+		{"PUT", "/admin/scripts/diff", []string{scopeFull}},
+		{"DELETE", "/admin/scripts/draft", []string{scopeFull}},
+	}
+	for _, tt := range tests {
+		for scope, secret := range secrets {
+			status, body := s.request(tt.method, tt.path, secret, map[string]string{})
+			allowed := false
+			for _, a := range tt.allowed {
+				allowed = allowed || a == scope
+			}
+			if allowed && (status == 401 || status == 403) {
+				t.Errorf("%s %s with a %s token = %d %s, want it allowed", tt.method, tt.path, scope, status, body)
+			}
+			if !allowed && status != 403 {
+				t.Errorf("%s %s with a %s token = %d %s, want 403", tt.method, tt.path, scope, status, body)
+			}
+		}
+	}
+}
+
+func TestTokenAuthRejectsInvalidTokens(t *testing.T) {
+	s := newTestServer(t, "scripts: []\n")
+
+	revoked, secret, err := tokenStore.Create("admin", "revoked", scopeFull, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := s.request("GET", "/admin/scripts", secret, nil); status != 200 {
+		t.Fatalf("valid token = %d", status)
+	}
+	if err := tokenStore.Revoke(revoked.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	expiredAt := time.Now().Add(-time.Hour)
+	_, expired, err := tokenStore.Create("admin", "expired", scopeFull, &expiredAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, secret := range map[string]string{
+		"revoked": secret,
+		"expired": expired,
+		"unknown": tokenPrefix + "0123456789abcdef",
+	} {
+		if status, body := s.request("GET", "/admin/scripts", secret, nil); status != 401 {
+			t.Errorf("%s token = %d %s, want 401", name, status, body)
+		}
+	}
+
+	// A token of a user who was since disabled stops working too
+	disabled := s.token("editor", scopeFull)
+	if status, _ := s.request("PUT", "/admin/users/editor", s.token("admin", scopeFull), map[string]any{"disabled": true}); status != 200 {
+		t.Fatalf("disable editor = %d", status)
+	}
+	if status, _ := s.request("GET", "/admin/scripts", disabled, nil); status != 401 {
+		t.Errorf("disabled owner's token = %d, want 401", status)
+	}
+}
+
+func TestTokenCannotCreateTokens(t *testing.T) {
+	s := newTestServer(t, "scripts: []\n")
+	secret := s.token("admin", scopeFull)
+
+	status, body := s.request("POST", "/admin/tokens", secret, map[string]string{"name": "more", "scope": scopeFull})
+	if status != http.StatusForbidden {
+		t.Errorf("create token with a token = %d %s, want 403", status, body)
+	}
+	if tokens := tokenStore.List(""); len(tokens) != 1 {
+		t.Errorf("%d tokens, want only the one used", len(tokens))
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	roleViewer = "viewer"
+	roleEditor = "editor"
+	roleAdmin  = "admin"
+)
+
+// roleRank orders roles so that each role includes the permissions of the
+// roles below it.
+var roleRank = map[string]int{
+	roleViewer: 1,
+	roleEditor: 2,
+	roleAdmin:  3,
+}
+
+var (
+	errUserExists    = errors.New("user already exists")
+	errUserNotFound  = errors.New("user not found")
+	errLastAdmin     = errors.New("at least one enabled admin is required")
+	errInvalidRole   = errors.New("role must be viewer, editor or admin")
+	errWeakPassword  = errors.New("password must be at least 8 characters")
+	errInvalidUserID = errors.New("username may only contain letters, digits, '.', '-' and '_'")
+)
+
+// User is a dashboard account. PasswordHash is a bcrypt hash and is never
+// returned by the API.
+type User struct {
+	Username     string `yaml:"username" json:"username"`
+	PasswordHash string `yaml:"password_hash" json:"-"`
+	Role         string `yaml:"role" json:"role"`
+	Disabled     bool   `yaml:"disabled,omitempty" json:"disabled"`
+}
+
+// users returns the accounts allowed to log in. Configs predating multiple
+// users only have the admin block, which is treated as a single admin user.
+func (c Config) users() []User {
+	if len(c.Users) > 0 {
+		return c.Users
+	}
+	if c.Admin.Username != "" {
+		return []User{{Username: c.Admin.Username, PasswordHash: c.Admin.Password, Role: roleAdmin}}
+	}
+	return nil
+}
+
+func (c Config) findUser(username string) (User, bool) {
+	for _, user := range c.users() {
+		if user.Username == username {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// migrateLegacyAdmin moves the single admin block into the users list so
+// that the list can be edited.
+func migrateLegacyAdmin(cfg *Config) {
+	if len(cfg.Users) == 0 && cfg.Admin.Username != "" {
+		cfg.Users = cfg.users()
+		cfg.Admin.Username = ""
+		cfg.Admin.Password = ""
+	}
+}
+
+func validateUsers(users []User) error {
+	if len(users) == 0 {
+		return errors.New("at least one user is required")
+	}
+
+	seen := map[string]bool{}
+	admins := 0
+	for _, user := range users {
+		if user.Username == "" || user.PasswordHash == "" {
+			return errors.New("users need a username and password_hash")
+		}
+		if seen[user.Username] {
+			return fmt.Errorf("duplicate username %q", user.Username)
+		}
+		seen[user.Username] = true
+
+		if _, ok := roleRank[user.Role]; !ok {
+			return fmt.Errorf("user %q: %w", user.Username, errInvalidRole)
+		}
+		if user.Role == roleAdmin && !user.Disabled {
+			admins++
+		}
+	}
+
+	if admins == 0 {
+		return errLastAdmin
+	}
+	return nil
+}
+
+func validUsername(username string) bool {
+	if username == "" {
+		return false
+	}
+	for _, r := range username {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune(".-_", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// requireRole rejects requests from users below the given role. It must run
+// after authMiddleware.
+func requireRole(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(User)
+		if !ok || roleRank[user.Role] < roleRank[role] {
+			return c.Status(403).JSON(fiber.Map{"error": fmt.Sprintf("This action requires the %s role", role)})
+		}
+		return c.Next()
+	}
+}
+
+func userErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errUserNotFound):
+		return 404
+	case errors.Is(err, errUserExists), errors.Is(err, errConfigChanged):
+		return 409
+	case errors.Is(err, errLastAdmin), errors.Is(err, errInvalidRole),
+		errors.Is(err, errWeakPassword), errors.Is(err, errInvalidUserID),
+		errors.Is(err, errInvalidConfig):
+		return 400
+	default:
+		return 500
+	}
+}
+
+func listUsersAPI(c *fiber.Ctx) error {
+	users := configStore.Get().users()
+	if users == nil {
+		users = []User{}
+	}
+	return c.JSON(users)
+}
+
+func createUserAPI(c *fiber.Ctx) error {
+	var body struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if !validUsername(body.Username) {
+		return c.Status(400).JSON(fiber.Map{"error": errInvalidUserID.Error()})
+	}
+	if body.Role == "" {
+		body.Role = roleViewer
+	}
+	if _, ok := roleRank[body.Role]; !ok {
+		return c.Status(400).JSON(fiber.Map{"error": errInvalidRole.Error()})
+	}
+	hash, err := hashPassword(body.Password)
+	if err != nil {
+		return c.Status(userErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	user := User{Username: body.Username, PasswordHash: hash, Role: body.Role}
+	err = configStore.Update(func(cfg *Config) error {
+		migrateLegacyAdmin(cfg)
+		if _, exists := cfg.findUser(user.Username); exists {
+			return errUserExists
+		}
+		cfg.Users = append(cfg.Users, user)
+		return nil
+	})
+	if err != nil {
+		return c.Status(userErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	log.Printf("User %s created user %s with role %s", currentUser(c), user.Username, user.Role)
+	recordAudit(c, AuditEntry{Action: "user.create", Details: fmt.Sprintf("user=%s role=%s", user.Username, user.Role)})
+	return c.JSON(user)
+}
+
+// updateUserAPI changes a user's role or enables/disables the account
+func updateUserAPI(c *fiber.Ctx) error {
+	username := c.Params("username")
+
+	var body struct {
+		Role     string `json:"role"`
+		Disabled *bool  `json:"disabled"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if body.Role != "" {
+		if _, ok := roleRank[body.Role]; !ok {
+			return c.Status(400).JSON(fiber.Map{"error": errInvalidRole.Error()})
+		}
+	}
+
+	var updated User
+	err := modifyUser(username, func(user *User) {
+		if body.Role != "" {
+			user.Role = body.Role
+		}
+		if body.Disabled != nil {
+			user.Disabled = *body.Disabled
+		}
+		updated = *user
+	})
+	if err != nil {
+		return c.Status(userErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	log.Printf("User %s updated user %s: role=%s disabled=%t", currentUser(c), username, updated.Role, updated.Disabled)
+	recordAudit(c, AuditEntry{Action: "user.update", Details: fmt.Sprintf("user=%s role=%s disabled=%t", username, updated.Role, updated.Disabled)})
+	return c.JSON(updated)
+}
+
+// resetPasswordAPI sets a new password. Admins can reset anyone's password;
+// other users can only change their own.
+func resetPasswordAPI(c *fiber.Ctx) error {
+	username := c.Params("username")
+	actor, _ := c.Locals("user").(User)
+	if actor.Role != roleAdmin && actor.Username != username {
+		return c.Status(403).JSON(fiber.Map{"error": "You can only change your own password"})
+	}
+
+	var body struct {
+		Password string `json:"password"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	hash, err := hashPassword(body.Password)
+	if err != nil {
+		return c.Status(userErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := modifyUser(username, func(user *User) { user.PasswordHash = hash }); err != nil {
+		return c.Status(userErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	log.Printf("User %s reset the password of %s", actor.Username, username)
+	recordAudit(c, AuditEntry{Action: "user.password", Details: "user=" + username})
+	return c.JSON(fiber.Map{"message": "Password updated successfully"})
+}
+
+// modifyUser applies fn to a user and saves the config, refusing changes
+// that would leave no enabled admin.
+func modifyUser(username string, fn func(user *User)) error {
+	return configStore.Update(func(cfg *Config) error {
+		migrateLegacyAdmin(cfg)
+		for i := range cfg.Users {
+			if cfg.Users[i].Username == username {
+				fn(&cfg.Users[i])
+				return validateUsers(cfg.Users)
+			}
+		}
+		return errUserNotFound
+	})
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < 8 {
+		return "", errWeakPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+const (
+	loginFailureLimit  = 5
+	loginFailureWindow = 15 * time.Minute
+	// loginThrottleSize bounds how many client addresses are tracked
+	loginThrottleSize = 10000
+	// maxLoginActorLength bounds the attacker-chosen username recorded for
+	// failed logins
+	maxLoginActorLength = 64
+)
+
+// loginThrottle counts failed logins per client address. A client that
+// reaches the limit is refused until its window has passed, and only its
+// first loginFailureLimit failures are written to the audit log.
+type loginThrottle struct {
+	mu       sync.Mutex
+	failures map[string]*loginFailures
+}
+
+type loginFailures struct {
+	count int
+	since time.Time
+}
+
+var loginAttempts = &loginThrottle{failures: map[string]*loginFailures{}}
+
+// blocked reports whether ip has used up its failed logins
+func (t *loginThrottle) blocked(ip string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, ok := t.failures[ip]
+	return ok && now.Sub(f.since) < loginFailureWindow && f.count >= loginFailureLimit
+}
+
+// fail records a failed login from ip and returns its failures in the
+// current window
+func (t *loginThrottle) fail(ip string, now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f, ok := t.failures[ip]
+	if !ok || now.Sub(f.since) >= loginFailureWindow {
+		if len(t.failures) >= loginThrottleSize {
+			t.prune(now)
+		}
+		f = &loginFailures{since: now}
+		t.failures[ip] = f
+	}
+	f.count++
+	return f.count
+}
+
+// reset forgets the failures of ip after a successful login
+func (t *loginThrottle) reset(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.failures, ip)
+}
+
+// prune drops expired windows, or everything if none have expired, so that
+// a flood of addresses cannot grow the map without bound
+func (t *loginThrottle) prune(now time.Time) {
+	for ip, f := range t.failures {
+		if now.Sub(f.since) >= loginFailureWindow {
+			delete(t.failures, ip)
+		}
+	}
+	if len(t.failures) >= loginThrottleSize {
+		t.failures = map[string]*loginFailures{}
+	}
+}
+
+// loginActor is the username recorded for a failed login, cut to a bounded
+// length
+func loginActor(username string) string {
+	if len(username) > maxLoginActorLength {
+		username = strings.ToValidUTF8(username[:maxLoginActorLength], "")
+	}
+	return username
+}
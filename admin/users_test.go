@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	throttle := &loginThrottle{failures: map[string]*loginFailures{}}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 1; i <= loginFailureLimit; i++ {
+		if throttle.blocked("192.0.2.1", now) {
+			t.Fatalf("blocked after %d failures", i-1)
+		}
+		if got := throttle.fail("192.0.2.1", now); got != i {
+			t.Fatalf("fail() = %d, want %d", got, i)
+		}
+	}
+	if !throttle.blocked("192.0.2.1", now) {
+		t.Error("not blocked after reaching the limit")
+	}
+	if throttle.blocked("192.0.2.2", now) {
+		t.Error("other address blocked")
+	}
+	if throttle.blocked("192.0.2.1", now.Add(loginFailureWindow)) {
+		t.Error("still blocked after the window")
+	}
+
+	throttle.reset("192.0.2.1")
+	if throttle.blocked("192.0.2.1", now) {
+		t.Error("blocked after a successful login")
+	}
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Webhooks notify other systems (chat, CI) of changes to scripts. Every audit
+// entry about a script is offered to the webhooks whose events match it, and
+// each webhook delivers its events in order from a queue of its own.
+
+const (
+	webhookAttempts  = 5
+	webhookQueueSize = 100
+	// webhookLogSize is how many deliveries the dashboard shows per webhook.
+	// The log is cut back to this many once it holds twice as many.
+	webhookLogSize = 50
+)
+
+// webhookBackoff is the wait before the second attempt; it doubles after
+// every further failure
+var webhookBackoff = time.Second
+
+var validWebhookName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// defaultWebhookEvents are sent to webhooks that do not list events
+var defaultWebhookEvents = []string{"script.*", "mirror.*"}
+
+// Webhook is an endpoint in config.yaml that receives script events
+type Webhook struct {
+	Name string `yaml:"name" json:"name"`
+	URL  string `yaml:"url" json:"url"`
+	// Secret signs request bodies. SecretEnv names an environment variable
+	// holding the secret instead, keeping it out of config.yaml.
+	Secret    string   `yaml:"secret,omitempty" json:"-"`
+	SecretEnv string   `yaml:"secret_env,omitempty" json:"secret_env,omitempty"`
+	Events    []string `yaml:"events,omitempty" json:"events"`
+}
+
+func (w Webhook) secret() string {
+	if w.SecretEnv != "" {
+		return os.Getenv(w.SecretEnv)
+	}
+	return w.Secret
+}
+
+// wants reports whether an audit action matches one of the webhook's event
+// patterns, such as "script.delete" or "script.*"
+func (w Webhook) wants(action string) bool {
+	events := w.Events
+	if len(events) == 0 {
+		events = defaultWebhookEvents
+	}
+	for _, pattern := range events {
+		if ok, _ := path.Match(pattern, action); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func validateWebhooks(webhooks []Webhook) error {
+	seen := map[string]bool{}
+	for _, webhook := range webhooks {
+		if !validWebhookName.MatchString(webhook.Name) {
+			return fmt.Errorf("webhook name %q is invalid: use letters, digits, '_' and '-'", webhook.Name)
+		}
+		if seen[webhook.Name] {
+			return fmt.Errorf("duplicate webhook name %q", webhook.Name)
+		}
+		seen[webhook.Name] = true
+
+		u, err := url.Parse(webhook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook %q needs an http or https url", webhook.Name)
+		}
+		for _, pattern := range webhook.Events {
+			if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+				return fmt.Errorf("webhook %q has invalid event %q", webhook.Name, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// WebhookPayload is the JSON body of a webhook request. Text summarizes the
+// event for chat services that display a text field.
+type WebhookPayload struct {
+	ID           string    `json:"id"`
+	Event        string    `json:"event"`
+	Time         time.Time `json:"time"`
+	Script       string    `json:"script,omitempty"`
+	Actor        string    `json:"actor,omitempty"`
+	BeforeSHA256 string    `json:"before_sha256,omitempty"`
+	AfterSHA256  string    `json:"after_sha256,omitempty"`
+	Details      string    `json:"details,omitempty"`
+	ScriptURL    string    `json:"script_url,omitempty"`
+	Text         string    `json:"text"`
+}
+
+// WebhookDelivery is one line of a webhook's delivery log
+type WebhookDelivery struct {
+	ID         string    `json:"id"`
+	Event      string    `json:"event"`
+	Script     string    `json:"script,omitempty"`
+	Time       time.Time `json:"time"`
+	Attempts   int       `json:"attempts"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Success    bool      `json:"success"`
+	DurationMS int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type webhookJob struct {
+	webhook Webhook
+	payload WebhookPayload
+}
+
+// WebhookDispatcher queues events for delivery and keeps the delivery logs in
+// a directory under DATA_PATH
+type WebhookDispatcher struct {
+	mu     sync.Mutex
+	logMu  sync.Mutex
+	dir    string
+	client *http.Client
+	queues map[string]chan webhookJob
+	// logLines counts the deliveries in each log, read on its first write
+	logLines map[string]int
+}
+
+var webhooks *WebhookDispatcher
+
+func newWebhookDispatcher(dir string) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		dir:      dir,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queues:   map[string]chan webhookJob{},
+		logLines: map[string]int{},
+	}
+}
+
+// Dispatch queues an audit entry for every webhook that wants it. It never
+// blocks; when a webhook's queue is full the event is logged as failed.
+func (d *WebhookDispatcher) Dispatch(entry AuditEntry) {
+	if entry.Script == "" {
+		return
+	}
+	for _, webhook := range configStore.Get().Webhooks {
+		if !webhook.wants(entry.Action) {
+			continue
+		}
+		job := webhookJob{webhook: webhook, payload: newWebhookPayload(entry)}
+		select {
+		case d.queue(webhook.Name) <- job:
+		default:
+			log.Printf("Webhook %s queue is full, dropping %s event", webhook.Name, entry.Action)
+			d.record(webhook.Name, WebhookDelivery{
+				ID:     job.payload.ID,
+				Event:  job.payload.Event,
+				Script: job.payload.Script,
+				Time:   time.Now().UTC(),
+				Error:  "queue full",
+			})
+		}
+	}
+}
+
+// queue returns a webhook's queue, starting its worker on first use. The
+// webhook settings travel with each job, so config reloads apply to events
+// queued afterwards.
+func (d *WebhookDispatcher) queue(name string) chan webhookJob {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	queue, ok := d.queues[name]
+	if !ok {
+		queue = make(chan webhookJob, webhookQueueSize)
+		d.queues[name] = queue
+		go d.work(name, queue)
+	}
+	return queue
+}
+
+func (d *WebhookDispatcher) work(name string, queue chan webhookJob) {
+	for job := range queue {
+		d.record(name, d.deliver(job, webhookAttempts))
+	}
+}
+
+// deliver posts a payload, retrying network errors, 429 and 5xx responses
+// with exponential backoff
+func (d *WebhookDispatcher) deliver(job webhookJob, attempts int) (delivery WebhookDelivery) {
+	delivery = WebhookDelivery{
+		ID:     job.payload.ID,
+		Event:  job.payload.Event,
+		Script: job.payload.Script,
+		Time:   time.Now().UTC(),
+	}
+	start := time.Now()
+	defer func() { delivery.DurationMS = time.Since(start).Milliseconds() }()
+
+	body, err := json.Marshal(job.payload)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+
+	wait := webhookBackoff
+	for delivery.Attempts < attempts {
+		if delivery.Attempts > 0 {
+			time.Sleep(wait)
+			wait *= 2
+		}
+		delivery.Attempts++
+
+		code, err := d.post(job.webhook, job.payload, body)
+		delivery.StatusCode = code
+		switch {
+		case err != nil:
+			delivery.Error = err.Error()
+		case code >= 200 && code <= 299:
+			delivery.Success = true
+			delivery.Error = ""
+			return delivery
+		default:
+			delivery.Error = fmt.Sprintf("endpoint returned %d %s", code, http.StatusText(code))
+			if code != http.StatusTooManyRequests && code < 500 {
+				return delivery
+			}
+		}
+		log.Printf("Webhook %s delivery %s attempt %d failed: %s", job.webhook.Name, delivery.ID, delivery.Attempts, delivery.Error)
+	}
+	return delivery
+}
+
+func (d *WebhookDispatcher) post(webhook Webhook, payload WebhookPayload, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "script-admin-webhook")
+	req.Header.Set("X-Script-Admin-Event", payload.Event)
+	req.Header.Set("X-Script-Admin-Delivery", payload.ID)
+	if secret := webhook.secret(); secret != "" {
+		req.Header.Set("X-Script-Admin-Signature", "sha256="+signWebhookBody(secret, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	return resp.StatusCode, nil
+}
+
+// signWebhookBody returns the hex HMAC-SHA256 of a request body, which
+// receivers recompute with the shared secret
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newWebhookPayload(entry AuditEntry) WebhookPayload {
+	payload := WebhookPayload{
+		ID:           newDeliveryID(),
+		Event:        entry.Action,
+		Time:         entry.Time,
+		Script:       entry.Script,
+		Actor:        entry.Actor,
+		BeforeSHA256: entry.BeforeSHA256,
+		AfterSHA256:  entry.AfterSHA256,
+		Details:      entry.Details,
+	}
+	if base := os.Getenv("PUBLIC_URL"); base != "" && entry.Action != "script.delete" {
+		payload.ScriptURL = strings.TrimSuffix(base, "/") + "/" + entry.Script
+	}
+
+	payload.Text = fmt.Sprintf("%s: %s", entry.Action, entry.Script)
+	if entry.Actor != "" {
+		payload.Text += " by " + entry.Actor
+	}
+	if payload.ScriptURL != "" {
+		payload.Text += " (" + payload.ScriptURL + ")"
+	}
+	return payload
+}
+
+func newDeliveryID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+func (d *WebhookDispatcher) logFile(name string) string {
+	return filepath.Join(d.dir, name+".jsonl")
+}
+
+// record appends a delivery to the webhook's log, rewriting the log with
+// only the newest deliveries once it has grown to twice webhookLogSize
+func (d *WebhookDispatcher) record(name string, delivery WebhookDelivery) {
+	if !delivery.Success {
+		log.Printf("Webhook %s failed to deliver %s event: %s", name, delivery.Event, delivery.Error)
+	}
+
+	d.logMu.Lock()
+	defer d.logMu.Unlock()
+	if err := d.appendDelivery(name, delivery); err != nil {
+		log.Printf("Failed to write webhook log: %v", err)
+	}
+}
+
+func (d *WebhookDispatcher) appendDelivery(name string, delivery WebhookDelivery) error {
+	lines, ok := d.logLines[name]
+	if !ok {
+		deliveries, err := d.readLog(name)
+		if err != nil {
+			return err
+		}
+		lines = len(deliveries)
+	}
+	if lines >= 2*webhookLogSize {
+		return d.trimLog(name, delivery)
+	}
+
+	line, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(d.logFile(name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	d.logLines[name] = lines + 1
+	return nil
+}
+
+// trimLog replaces a webhook's log with its newest deliveries and delivery
+func (d *WebhookDispatcher) trimLog(name string, delivery WebhookDelivery) error {
+	deliveries, err := d.readLog(name)
+	if err != nil {
+		return err
+	}
+	if len(deliveries) >= webhookLogSize {
+		deliveries = deliveries[len(deliveries)-webhookLogSize+1:]
+	}
+	deliveries = append(deliveries, delivery)
+
+	var buf bytes.Buffer
+	for _, delivery := range deliveries {
+		line, err := json.Marshal(delivery)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+	}
+	if err := writeFileAtomic(d.logFile(name), buf.Bytes(), 0640); err != nil {
+		return err
+	}
+	d.logLines[name] = len(deliveries)
+	return nil
+}
+
+// readLog returns the deliveries in a webhook's log, oldest first
+func (d *WebhookDispatcher) readLog(name string) ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+	f, err := os.Open(d.logFile(name))
+	if os.IsNotExist(err) {
+		return deliveries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var delivery WebhookDelivery
+		if err := json.Unmarshal(scanner.Bytes(), &delivery); err != nil {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// Deliveries returns the newest deliveries of a webhook, newest first
+func (d *WebhookDispatcher) Deliveries(name string, limit int) ([]WebhookDelivery, error) {
+	d.logMu.Lock()
+	defer d.logMu.Unlock()
+
+	deliveries, err := d.readLog(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(deliveries) > limit {
+		deliveries = deliveries[len(deliveries)-limit:]
+	}
+	for i, j := 0, len(deliveries)-1; i < j; i, j = i+1, j-1 {
+		deliveries[i], deliveries[j] = deliveries[j], deliveries[i]
+	}
+	return deliveries, nil
+}
+
+func findWebhook(name string) (Webhook, bool) {
+	for _, webhook := range configStore.Get().Webhooks {
+		if webhook.Name == name {
+			return webhook, true
+		}
+	}
+	return Webhook{}, false
+}
+
+// webhookInfo is a webhook as listed on the dashboard, without its secret
+type webhookInfo struct {
+	Webhook
+	Signed       bool             `json:"signed"`
+	LastDelivery *WebhookDelivery `json:"last_delivery,omitempty"`
+}
+
+func listWebhooksAPI(c *fiber.Ctx) error {
+	list := []webhookInfo{}
+	for _, webhook := range configStore.Get().Webhooks {
+		info := webhookInfo{Webhook: webhook, Signed: webhook.secret() != ""}
+		if len(info.Events) == 0 {
+			info.Events = defaultWebhookEvents
+		}
+		if deliveries, err := webhooks.Deliveries(webhook.Name, 1); err == nil && len(deliveries) > 0 {
+			info.LastDelivery = &deliveries[0]
+		}
+		list = append(list, info)
+	}
+	return c.JSON(list)
+}
+
+func webhookDeliveriesAPI(c *fiber.Ctx) error {
+	webhook, ok := findWebhook(c.Params("name"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Webhook not found"})
+	}
+	deliveries, err := webhooks.Deliveries(webhook.Name, webhookLogSize)
+	if err != nil {
+		log.Printf("Failed to read webhook log: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to read delivery log"})
+	}
+	return c.JSON(deliveries)
+}
+
+// testWebhookAPI sends a ping event right away, without retries, and returns
+// the outcome
+func testWebhookAPI(c *fiber.Ctx) error {
+	webhook, ok := findWebhook(c.Params("name"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Webhook not found"})
+	}
+	payload := WebhookPayload{
+		ID:    newDeliveryID(),
+		Event: "ping",
+		Time:  time.Now().UTC(),
+		Actor: currentUser(c),
+		Text:  "Test notification from the script server",
+	}
+	delivery := webhooks.deliver(webhookJob{webhook: webhook, payload: payload}, 1)
+	webhooks.record(webhook.Name, delivery)
+	return c.JSON(delivery)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records the requests of a test endpoint, which answers
+// with the queued status codes and 200 once they run out
+type webhookReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	if len(r.statuses) > 0 {
+		w.WriteHeader(r.statuses[0])
+		r.statuses = r.statuses[1:]
+	}
+}
+
+func (r *webhookReceiver) paths() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var paths []string
+	for _, req := range r.requests {
+		paths = append(paths, req.URL.Path)
+	}
+	return paths
+}
+
+func startWebhookReceiver(t *testing.T, statuses ...int) (*webhookReceiver, string) {
+	t.Helper()
+	receiver := &webhookReceiver{statuses: statuses}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+	return receiver, server.URL
+}
+
+func newTestWebhookDispatcher(t *testing.T) *WebhookDispatcher {
+	t.Helper()
+	oldBackoff := webhookBackoff
+	webhookBackoff = time.Millisecond
+	t.Cleanup(func() { webhookBackoff = oldBackoff })
+	return newWebhookDispatcher(filepath.Join(t.TempDir(), "webhooks"))
+}
+
+func testWebhookJob(url, secret string) webhookJob {
+	return webhookJob{
+		webhook: Webhook{Name: "ci", URL: url, Secret: secret},
+		payload: newWebhookPayload(AuditEntry{Action: "script.content", Script: "docker", Actor: "alice"}),
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	receiver, url := startWebhookReceiver(t)
+	d := newTestWebhookDispatcher(t)
+
+	delivery := d.deliver(testWebhookJob(url, "topsecret"), webhookAttempts)
+	if !delivery.Success || delivery.Attempts != 1 || delivery.StatusCode != 200 {
+		t.Fatalf("delivery = %+v, want success on the first attempt", delivery)
+	}
+
+	req, body := receiver.requests[0], receiver.bodies[0]
+	signature := req.Header.Get("X-Script-Admin-Signature")
+	mac := hmac.New(sha256.New, []byte("topsecret"))
+	mac.Write(body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Errorf("signature = %q, want %q", signature, want)
+	}
+	if event := req.Header.Get("X-Script-Admin-Event"); event != "script.content" {
+		t.Errorf("event header = %q", event)
+	}
+	var payload WebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.ID != delivery.ID || payload.Script != "docker" || payload.Text != "script.content: docker by alice" {
+		t.Errorf("payload = %+v", payload)
+	}
+
+	// Without a secret the request is not signed
+	d.deliver(testWebhookJob(url, ""), 1)
+	if signature := receiver.requests[1].Header.Get("X-Script-Admin-Signature"); signature != "" {
+		t.Errorf("unsigned webhook sent signature %q", signature)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	receiver, url := startWebhookReceiver(t, 503, 429, 500)
+	d := newTestWebhookDispatcher(t)
+
+	delivery := d.deliver(testWebhookJob(url, ""), webhookAttempts)
+	if !delivery.Success || delivery.Attempts != 4 || delivery.Error != "" {
+		t.Errorf("delivery = %+v, want success on the fourth attempt", delivery)
+	}
+	if len(receiver.requests) != 4 {
+		t.Errorf("endpoint got %d requests, want 4", len(receiver.requests))
+	}
+
+	receiver, url = startWebhookReceiver(t, 500, 500, 500)
+	delivery = d.deliver(testWebhookJob(url, ""), 3)
+	if delivery.Success || delivery.Attempts != 3 || delivery.StatusCode != 500 {
+		t.Errorf("delivery = %+v, want failure after 3 attempts", delivery)
+	}
+	if len(receiver.requests) != 3 {
+		t.Errorf("endpoint got %d requests, want 3", len(receiver.requests))
+	}
+}
+
+func TestWebhookNoRetryOnClientError(t *testing.T) {
+	receiver, url := startWebhookReceiver(t, 404, 404)
+	d := newTestWebhookDispatcher(t)
+
+	delivery := d.deliver(testWebhookJob(url, ""), webhookAttempts)
+	if delivery.Success || delivery.Attempts != 1 || delivery.Error != "endpoint returned 404 Not Found" {
+		t.Errorf("delivery = %+v, want a single failed attempt", delivery)
+	}
+	if len(receiver.requests) != 1 {
+		t.Errorf("endpoint got %d requests, want 1", len(receiver.requests))
+	}
+}
+
+func TestWebhookDispatchFiltersEvents(t *testing.T) {
+	receiver, url := startWebhookReceiver(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := testConfig[:strings.Index(testConfig, "webhooks:")] + `webhooks:
+  - name: scripts
+    url: ` + url + `/scripts
+    events: ["script.*"]
+  - name: deletes
+    url: ` + url + `/deletes
+    events: ["script.delete"]
+  - name: default
+    url: ` + url + `/default
+`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	oldStore := configStore
+	configStore = newConfigStore(path)
+	t.Cleanup(func() { configStore = oldStore })
+	if err := configStore.Load(); err != nil {
+		t.Fatal(err)
+	}
+	d := newTestWebhookDispatcher(t)
+
+	d.Dispatch(AuditEntry{Action: "script.content", Script: "docker"})
+	d.Dispatch(AuditEntry{Action: "mirror.changed", Script: "docker"})
+	// Entries that are not about a script are never sent
+	d.Dispatch(AuditEntry{Action: "login", Actor: "alice"})
+
+	want := map[string]int{"scripts": 1, "deletes": 0, "default": 2}
+	deadline := time.Now().Add(5 * time.Second)
+	for name, count := range want {
+		for {
+			deliveries, err := d.Deliveries(name, webhookLogSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(deliveries) == count {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s has %d deliveries, want %d", name, len(deliveries), count)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	paths := receiver.paths()
+	if len(paths) != 3 || strings.Count(strings.Join(paths, " "), "/default") != 2 {
+		t.Errorf("requests to %v, want /scripts once and /default twice", paths)
+	}
+	deliveries, _ := d.Deliveries("default", webhookLogSize)
+	if deliveries[0].Event != "mirror.changed" || deliveries[1].Event != "script.content" {
+		t.Errorf("default deliveries = %+v, want newest first", deliveries)
+	}
+}
+
+func TestWebhookDeliveryLog(t *testing.T) {
+	d := newTestWebhookDispatcher(t)
+
+	for i := 0; i < 3*webhookLogSize; i++ {
+		d.record("ci", WebhookDelivery{Attempts: i, Success: true})
+	}
+
+	deliveries, err := d.Deliveries("ci", webhookLogSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != webhookLogSize || deliveries[0].Attempts != 3*webhookLogSize-1 {
+		t.Fatalf("got %d deliveries starting at %d, want the newest %d", len(deliveries), deliveries[0].Attempts, webhookLogSize)
+	}
+	if last, _ := d.Deliveries("ci", 1); len(last) != 1 || last[0].Attempts != 3*webhookLogSize-1 {
+		t.Errorf("last delivery = %+v", last)
+	}
+
+	// The log is cut back instead of growing with every delivery
+	data, err := os.ReadFile(d.logFile("ci"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines > 2*webhookLogSize {
+		t.Errorf("log has %d lines, want at most %d", lines, 2*webhookLogSize)
+	}
+
+	// A new dispatcher, as after a restart, keeps appending to the log
+	restarted := newWebhookDispatcher(d.dir)
+	restarted.record("ci", WebhookDelivery{Attempts: 3 * webhookLogSize})
+	if last, _ := restarted.Deliveries("ci", 1); len(last) != 1 || last[0].Attempts != 3*webhookLogSize {
+		t.Errorf("last delivery after restart = %+v", last)
+	}
+
+	if none, err := d.Deliveries("other", webhookLogSize); err != nil || len(none) != 0 {
+		t.Errorf("deliveries of a webhook without a log = %v, %v", none, err)
+	}
+}